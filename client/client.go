@@ -1,11 +1,20 @@
 package client
 
+import "github.com/longyufei109/leaf-go/entity"
+
 type Config struct {
 	Endpoints   []string
 	RequestPath string
 	Query       string
+	DecodePath  string // 为空时使用 /api/decode
 }
 
 type Client interface {
 	GetId(key string) (int64, error)
+	// 一次获取n个id
+	GetIds(key string, n int) ([]int64, error)
+	// 解析snowflake id，只有snowflake模式的服务端支持
+	Decode(id int64) (entity.IdInfo, error)
+	// 关闭连接，之后不能再使用
+	Close() error
 }
@@ -1,27 +1,101 @@
 package client
 
 import (
-	"fmt"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 )
 
-func TestHttpClient_GetId(t *testing.T) {
-	conf := Config{
-		Endpoints:   []string{"localhost:8080"},
+// 模拟服务端，按请求顺序分配连续的id
+func newTestServer(t *testing.T) *httptest.Server {
+	var lock sync.Mutex
+	next := int64(1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/id" || r.URL.Query().Get("key") != "test" {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(response{Id: -1, Msg: "not support key"})
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		resp := response{Id: next}
+		if s := r.URL.Query().Get("count"); s != "" {
+			count, _ := strconv.Atoi(s)
+			for i := 0; i < count; i++ {
+				resp.Ids = append(resp.Ids, next+int64(i))
+			}
+			next += int64(count)
+		} else {
+			next++
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func newTestClient(ts *httptest.Server) Client {
+	return NewHttpClient(Config{
+		Endpoints:   []string{strings.TrimPrefix(ts.URL, "http://")},
 		RequestPath: "/api/id",
 		Query:       "key",
-	}
-	c := NewHttpClient(conf)
-	wg := sync.WaitGroup{}
+	})
+}
+
+func TestHttpClient_GetId(t *testing.T) {
+	c := newTestClient(newTestServer(t))
+	defer c.Close()
+
+	var lock sync.Mutex
+	seen := map[int64]bool{}
+	var wg sync.WaitGroup
 	num := 100
 	wg.Add(num)
 	for i := 0; i < num; i++ {
 		go func() {
 			defer wg.Done()
 			id, err := c.GetId("test")
-			fmt.Println("Id:", id, "err:", err)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			lock.Lock()
+			seen[id] = true
+			lock.Unlock()
 		}()
 	}
 	wg.Wait()
+	if len(seen) != num {
+		t.Fatalf("expect %d distinct ids, got %d", num, len(seen))
+	}
+
+	if _, err := c.GetId("unknown"); err == nil {
+		t.Fatal("expect error for unknown key")
+	}
+}
+
+func TestHttpClient_GetIds(t *testing.T) {
+	c := newTestClient(newTestServer(t))
+	defer c.Close()
+
+	ids, err := c.GetIds("test", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 10 {
+		t.Fatalf("expect 10 ids, got %d", len(ids))
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] != ids[i-1]+1 {
+			t.Fatalf("ids not contiguous:%v", ids)
+		}
+	}
+
+	if _, err = c.GetIds("unknown", 10); err == nil {
+		t.Fatal("expect error for unknown key")
+	}
 }
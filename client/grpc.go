@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/longyufei109/leaf-go/entity"
+	"github.com/longyufei109/leaf-go/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+type grpcClient struct {
+	conf    Config
+	conns   []*grpc.ClientConn
+	clients []pb.LeafClient
+	pos     uint32 // 溢出后从0开始
+	total   uint32
+}
+
+// 每个endpoint建立一个连接，请求时轮询
+func NewGrpcClient(conf Config) (Client, error) {
+	c := &grpcClient{
+		conf: conf,
+	}
+	for _, endpoint := range conf.Endpoints {
+		conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("dial %s failed, err:%v", endpoint, err)
+		}
+		c.conns = append(c.conns, conn)
+		c.clients = append(c.clients, pb.NewLeafClient(conn))
+	}
+	if len(c.clients) == 0 {
+		return nil, fmt.Errorf("no endpoints")
+	}
+	c.total = uint32(len(c.clients))
+	return c, nil
+}
+
+func (c *grpcClient) GetId(key string) (int64, error) {
+	resp, err := c.getclient().Gen(context.Background(), &pb.GenRequest{Key: key})
+	if err != nil {
+		return -1, err
+	}
+	return resp.Id, nil
+}
+
+func (c *grpcClient) GetIds(key string, n int) ([]int64, error) {
+	if n <= 0 || n > math.MaxInt32 { // 请求中count为int32
+		return nil, fmt.Errorf("invalid count:%d", n)
+	}
+	resp, err := c.getclient().GenBatch(context.Background(), &pb.GenBatchRequest{Key: key, Count: int32(n)})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Ids, nil
+}
+
+func (c *grpcClient) Decode(id int64) (entity.IdInfo, error) {
+	resp, err := c.getclient().Decode(context.Background(), &pb.DecodeRequest{Id: id})
+	if err != nil {
+		return entity.IdInfo{}, err
+	}
+	return entity.IdInfo{
+		Id:           resp.Id,
+		Timestamp:    resp.Timestamp,
+		Time:         time.Unix(0, resp.Timestamp*1e6),
+		DatacenterId: resp.DatacenterId,
+		WorkerId:     resp.WorkerId,
+		Sequence:     resp.Sequence,
+	}, nil
+}
+
+func (c *grpcClient) Close() error {
+	var err error
+	for _, conn := range c.conns {
+		if closeErr := conn.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (c *grpcClient) getclient() pb.LeafClient {
+	pos := atomic.AddUint32(&c.pos, 1)
+	return c.clients[pos%c.total]
+}
@@ -3,17 +3,20 @@ package client
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/longyufei109/leaf-go/entity"
 	"io/ioutil"
 	"net/http"
 	"sync/atomic"
+	"time"
 )
 
 type httpClient struct {
-	cli   *http.Client
-	conf  Config
-	urls  []string
-	pos   int32
-	total int32
+	cli        *http.Client
+	conf       Config
+	urls       []string
+	decodeUrls []string
+	pos        uint32 // 溢出后从0开始
+	total      uint32
 }
 
 func NewHttpClient(conf Config) Client {
@@ -21,16 +24,75 @@ func NewHttpClient(conf Config) Client {
 		cli:  http.DefaultClient,
 		conf: conf,
 	}
+	decodePath := conf.DecodePath
+	if decodePath == "" {
+		decodePath = "/api/decode"
+	}
 	for _, endpoint := range conf.Endpoints {
 		url := fmt.Sprint("http://", endpoint, conf.RequestPath, "?", conf.Query, "=%s")
 		c.urls = append(c.urls, url)
+		c.decodeUrls = append(c.decodeUrls, fmt.Sprint("http://", endpoint, decodePath, "?id=%d"))
 	}
-	c.total = int32(len(c.urls))
+	c.total = uint32(len(c.urls))
 	return c
 }
 
 func (c *httpClient) GetId(key string) (int64, error) {
 	url := fmt.Sprintf(c.geturl(), key)
+	r, code, err := c.get(url)
+	if err != nil {
+		return code, err
+	}
+	if r.Id <= 0 || r.Msg != "" {
+		return -4, fmt.Errorf("id:%d, err:%s", r.Id, r.Msg)
+	}
+	return r.Id, nil
+}
+
+func (c *httpClient) GetIds(key string, n int) ([]int64, error) {
+	url := fmt.Sprintf(c.geturl(), key) + fmt.Sprintf("&count=%d", n)
+	r, _, err := c.get(url)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Ids) != n || r.Msg != "" {
+		return nil, fmt.Errorf("ids:%d, err:%s", len(r.Ids), r.Msg)
+	}
+	return r.Ids, nil
+}
+
+func (c *httpClient) Decode(id int64) (entity.IdInfo, error) {
+	pos := atomic.AddUint32(&c.pos, 1)
+	url := fmt.Sprintf(c.decodeUrls[pos%c.total], id)
+	r := decodeResponse{}
+	if _, err := c.getJson(url, &r); err != nil {
+		return entity.IdInfo{}, err
+	}
+	if r.Msg != "" {
+		return entity.IdInfo{}, fmt.Errorf("id:%d, err:%s", id, r.Msg)
+	}
+	return entity.IdInfo{
+		Id:           r.Id,
+		Timestamp:    r.Timestamp,
+		Time:         time.Unix(0, r.Timestamp*1e6),
+		DatacenterId: r.DatacenterId,
+		WorkerId:     r.WorkerId,
+		Sequence:     r.Sequence,
+	}, nil
+}
+
+// 使用 http.DefaultClient，不关闭共享的连接
+func (c *httpClient) Close() error {
+	return nil
+}
+
+// 出错时code为GetId返回的错误id
+func (c *httpClient) get(url string) (r response, code int64, err error) {
+	code, err = c.getJson(url, &r)
+	return
+}
+
+func (c *httpClient) getJson(url string, r interface{}) (code int64, err error) {
 	resp, err := c.cli.Get(url)
 	if err != nil {
 		return -1, err
@@ -42,23 +104,29 @@ func (c *httpClient) GetId(key string) (int64, error) {
 		return -2, err
 	}
 
-	r := response{}
-	err = json.Unmarshal(data, &r)
+	err = json.Unmarshal(data, r)
 	if err != nil {
 		return -3, err
 	}
-	if r.Id <= 0 || r.Msg != "" {
-		return -4, fmt.Errorf("id:%d, err:%s", r.Id, r.Msg)
-	}
-	return r.Id, nil
+	return 0, nil
 }
 
 type response struct {
-	Id  int64  `json:"id"`
-	Msg string `json:"msg"`
+	Id  int64   `json:"id"`
+	Ids []int64 `json:"ids,omitempty"`
+	Msg string  `json:"msg"`
+}
+
+type decodeResponse struct {
+	Id           int64  `json:"id"`
+	Timestamp    int64  `json:"timestamp"`
+	DatacenterId int64  `json:"datacenter_id"`
+	WorkerId     int64  `json:"worker_id"`
+	Sequence     int64  `json:"sequence"`
+	Msg          string `json:"msg"`
 }
 
 func (c *httpClient) geturl() string {
-	pos := atomic.AddInt32(&c.pos, 1)
+	pos := atomic.AddUint32(&c.pos, 1)
 	return c.urls[pos%c.total]
 }
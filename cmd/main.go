@@ -1,13 +1,32 @@
 package main
 
 import (
+	"fmt"
 	"github.com/longyufei109/leaf-go/config"
 	"github.com/longyufei109/leaf-go/server"
+	"os"
 )
 
+// 不带参数时启动服务，否则执行管理命令，如 ./cmd workers list
 func main() {
 	if err := config.Init(); err != nil {
 		panic(err)
 	}
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 	server.Start()
 }
+
+func runCommand(args []string) error {
+	switch args[0] {
+	case "workers":
+		return runWorkers(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q, usage:\n%s", args[0], workersUsage)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/service/snowflake/zookeeper"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+const workersUsage = `  workers list                        列出zookeeper中已注册的节点
+  workers reclaim -days N [-dry-run]  回收超过N天未上报的节点，其workerId可被新节点复用`
+
+// zookeeper方式获取workerId时管理已注册的节点，使用 leaf.yaml 中的 zookeeper 配置
+func runWorkers(args []string) error {
+	c := config.Global
+	if c.Mode != config.Mode_Snowflake || c.Snowflake.WorkerId >= 0 ||
+		c.Snowflake.WorkerIdProvider != config.WorkerIdProvider_Zookeeper {
+		return errors.New("workers command requires snowflake mode with workerId from zookeeper")
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("usage:\n%s", workersUsage)
+	}
+	admin := zookeeper.NewAdmin(&c.Zookeeper, c.Snowflake.WorkerIdBits)
+
+	switch args[0] {
+	case "list":
+		workers, err := admin.Workers()
+		if err != nil {
+			return err
+		}
+		printWorkers(workers)
+		return nil
+	case "reclaim":
+		fs := flag.NewFlagSet("workers reclaim", flag.ContinueOnError)
+		days := fs.Int("days", 0, "回收超过多少天未上报的节点")
+		dryRun := fs.Bool("dry-run", false, "只列出将被回收的节点")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		workers, err := admin.Reclaim(*days, *dryRun)
+		if err != nil {
+			return err
+		}
+		printWorkers(workers)
+		if *dryRun {
+			fmt.Printf("%d workers to be reclaimed\n", len(workers))
+		} else {
+			fmt.Printf("%d workers reclaimed\n", len(workers))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown subcommand %q, usage:\n%s", args[0], workersUsage)
+	}
+}
+
+func printWorkers(workers []zookeeper.Worker) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "WORKER_ID\tENDPOINT\tLAST_HEARTBEAT\tNODE")
+	for _, worker := range workers {
+		fmt.Fprintf(w, "%d\t%s:%s\t%s\t%s\n", worker.WorkerId, worker.Ip, worker.Port,
+			time.Unix(0, worker.LastHeartbeat*1e6).Format(time.RFC3339), worker.Node)
+	}
+	_ = w.Flush()
+}
@@ -1,7 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"github.com/spf13/viper"
+	"strconv"
+	"time"
 )
 
 const (
@@ -10,8 +13,25 @@ const (
 )
 
 const (
-	DB_Type_MySQL = 1
-	DB_Type_Redis = 2
+	DB_Type_MySQL    = 1
+	DB_Type_Redis    = 2
+	DB_Type_Postgres = 3
+	DB_Type_SQLite   = 4
+)
+
+// snowflake.workerId < 0 时获取workerId的方式
+const (
+	WorkerIdProvider_Zookeeper = 1
+	WorkerIdProvider_Etcd      = 2
+	WorkerIdProvider_DB        = 3
+	WorkerIdProvider_K8s       = 4
+)
+
+// workerIdProvider=4 时StatefulSet序号的来源
+const (
+	K8s_Source_Hostname = 1
+	K8s_Source_Env      = 2
+	K8s_Source_File     = 3
 )
 
 type Config struct {
@@ -20,13 +40,44 @@ type Config struct {
 	Segment   Segment
 	Snowflake Snowflake
 	Zookeeper Zookeeper
+	Etcd      Etcd
+	DBWorker  DBWorker
+	K8s       K8s
 	DB        DBConfig
 
 	Http HttpConfig
+	Grpc GrpcConfig
 }
 
 type Snowflake struct {
 	WorkerId int64
+	// WorkerId < 0 时获取workerId的方式，1: zookeeper 2: etcd 3: db 4: k8s，为0时使用zookeeper
+	WorkerIdProvider int
+
+	// 起始时间，RFC3339格式(如 2020-10-24T11:11:11+08:00)或毫秒时间戳，不配置时使用默认值
+	Twepoch       string
+	TwepochMillis int64 `mapstructure:"-"` // 由 Twepoch 解析得到，0 表示未配置
+
+	// 保存已使用的最大时间戳的文件，重启时等待系统时间超过该值，为空时不保存
+	TimestampFile string
+	// 启动时等待时钟追上已使用时间戳的最长时间，毫秒，为0时使用默认值5000
+	MaxStartupWait int64
+
+	// 运行中时钟回拨的处理策略，0: 等待 1: 直接失败 2: 借用未来时间 3: 切换到备用workerId
+	ClockPolicy int
+	// 策略为0时最多等待的毫秒数，为2时最多领先系统时间的毫秒数，为0时使用默认值5
+	MaxBackwardMs int64
+	// 策略为3时使用的备用workerId，需保证不会被其它节点使用，仅直接配置workerId时可用
+	SpareWorkerIds []int64
+
+	// 使用无锁实现，不支持 ClockPolicy 3
+	LockFree bool
+
+	// 位分配，都为0时使用默认的 41 bits timestamp | 10 bits workerId | 12 bits sequence
+	WorkerIdBits     int64
+	SequenceBits     int64
+	DatacenterIdBits int64
+	DatacenterId     int64
 
 	WorkerIdGetter func() int64
 }
@@ -37,21 +88,81 @@ type Zookeeper struct {
 	Port     string
 	User     string
 	Pwd      string
+	// 启动时本机时间与其它存活节点时间平均值的最大偏差，毫秒，为0时使用默认值5000，小于0时不检查
+	MaxPeerClockSkew int64
+}
+
+type Etcd struct {
+	LeafName  string
+	Endpoints []string
+	Port      string // 本服务的端口，与ip一起记录在注册信息中
+	User      string
+	Pwd       string
+	LeaseTTL  int64 // 租约时长，秒，为0时使用默认值10
+}
+
+// workerIdProvider=3 时使用，数据库配置见 DBConfig
+type DBWorker struct {
+	Port     string // 本服务的端口，与ip一起作为workerId持有者的标识
+	LeaseTTL int64  // 租约时长，秒，为0时使用默认值30
+}
+
+// workerIdProvider=4 时使用，workerId = StatefulSet中pod的序号 + Offset
+type K8s struct {
+	Source int    // 序号的来源，1: 主机名 2: 环境变量 3: downward API 文件，为0时使用主机名
+	Env    string // Source=2 时的环境变量名，默认 POD_NAME
+	File   string // Source=3 时的文件路径，默认 /etc/podinfo/labels
+	Offset int64  // 多个数据中心共用workerId范围时，各自配置不同的值
 }
 
 type Segment struct {
-	CacheDir string
+	CacheDir        string
+	RefreshInterval int64 // 从数据库刷新biz_tag的间隔，秒，为0时使用默认值60
+	AutoCreate      SegmentAutoCreate
+	StepPolicy      SegmentStepPolicy   // 动态调整step的策略
+	TagStepPolicies []SegmentStepPolicy // 指定biz_tag的策略，优先于StepPolicy
+	PreloadRatio    float64             // 当前segment消耗超过该比例时预加载下一个，(0, 1)，为0时使用0.1
+	Segments        int                 // 每个biz_tag缓冲的segment数量(包括当前的)，2-16，为0时使用2
+}
+
+// 加载下一个segment时计算step的策略，见 service/segment/step.go
+type SegmentStepPolicy struct {
+	Tags           []string // 仅 TagStepPolicies 中有效
+	Mode           int      // 1: 按获取segment的间隔倍增或缩小(默认) 2: 按当前segment的消耗速度预测
+	TargetDuration int64    // 一个segment期望使用的时长，秒，为0时使用900
+	MinStep        int64    // 为0时使用数据库中的step
+	MaxStep        int64    // 为0时使用1000000
+	Factor         float64  // mode=1时的倍数，为0时使用2
+}
+
+// 请求未知的key时自动创建，Patterns为空时不启用
+type SegmentAutoCreate struct {
+	Patterns []string // 正则，key需完整匹配其中之一
+	Step     int64    // 为0时使用默认值1000
+	MaxId    int64    // 起始值，为0时使用1
 }
 
 type DBConfig struct {
-	Type       int
-	DataSource []string
+	Type            int
+	DataSource      []string
+	AutoCreateTable bool // 启动时自动创建leaf_alloc、leaf_worker表(如果不存在)，不支持redis
 }
 
 type HttpConfig struct {
 	Addr        string
 	RequestPath string
 	Query       string
+	DecodePath  string // 解析snowflake id的路径，默认 /api/decode
+	HealthPath  string // 健康检查的路径，默认 /healthz
+	ReadyPath   string // 就绪检查的路径，默认 /readyz
+	StatusPath  string // 内部状态的路径，默认 /status
+	MetricsPath string // prometheus指标的路径，默认 /metrics
+	AdminPath   string // 管理接口的路径前缀，为空时不启用
+	AdminToken  string // 管理接口的认证token，请求头 Authorization: Bearer {token}，为空时不启用管理接口
+}
+
+type GrpcConfig struct {
+	Addr string // 为空时不启动grpc server
 }
 
 var Global Config
@@ -73,13 +184,39 @@ func InitByViper(viper *viper.Viper) error {
 	if Global.Mode == Mode_Snowflake {
 		if err := v.UnmarshalKey("snowflake", &Global.Snowflake); err != nil {
 			return err
-		} else if Global.Snowflake.WorkerId >= 0 { // 否则 需要自己设置 Snowflake.WorkerIdGetter
+		}
+		var err error
+		if Global.Snowflake.TwepochMillis, err = ParseTwepoch(Global.Snowflake.Twepoch); err != nil {
+			return err
+		}
+		if Global.Snowflake.WorkerId >= 0 { // 否则 需要自己设置 Snowflake.WorkerIdGetter
 			Global.Snowflake.WorkerIdGetter = func() int64 {
 				return Global.Snowflake.WorkerId
 			}
 		} else {
-			if err := v.UnmarshalKey("zookeeper", &Global.Zookeeper); err != nil {
-				return err
+			switch Global.Snowflake.WorkerIdProvider {
+			case 0, WorkerIdProvider_Zookeeper:
+				Global.Snowflake.WorkerIdProvider = WorkerIdProvider_Zookeeper
+				if err := v.UnmarshalKey("zookeeper", &Global.Zookeeper); err != nil {
+					return err
+				}
+			case WorkerIdProvider_Etcd:
+				if err := v.UnmarshalKey("etcd", &Global.Etcd); err != nil {
+					return err
+				}
+			case WorkerIdProvider_K8s:
+				if err := v.UnmarshalKey("k8s", &Global.K8s); err != nil {
+					return err
+				}
+			case WorkerIdProvider_DB:
+				if err := v.UnmarshalKey("dbWorker", &Global.DBWorker); err != nil {
+					return err
+				}
+				if err := v.UnmarshalKey("db", &Global.DB); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("not support workerIdProvider:%d", Global.Snowflake.WorkerIdProvider)
 			}
 		}
 	} else if Global.Mode == Mode_Segment {
@@ -90,5 +227,41 @@ func InitByViper(viper *viper.Viper) error {
 			return err
 		}
 	}
+	if err := v.UnmarshalKey("http", &Global.Http); err != nil {
+		return err
+	}
+	if Global.Http.DecodePath == "" {
+		Global.Http.DecodePath = "/api/decode"
+	}
+	if Global.Http.HealthPath == "" {
+		Global.Http.HealthPath = "/healthz"
+	}
+	if Global.Http.ReadyPath == "" {
+		Global.Http.ReadyPath = "/readyz"
+	}
+	if Global.Http.StatusPath == "" {
+		Global.Http.StatusPath = "/status"
+	}
+	if Global.Http.MetricsPath == "" {
+		Global.Http.MetricsPath = "/metrics"
+	}
+	if err := v.UnmarshalKey("grpc", &Global.Grpc); err != nil {
+		return err
+	}
 	return nil
 }
+
+// 解析 snowflake.twepoch，支持RFC3339格式和毫秒时间戳，为空时返回0
+func ParseTwepoch(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return ms, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid snowflake.twepoch:%s, should be RFC3339 or epoch milliseconds", s)
+	}
+	return t.UnixNano() / 1e6, nil
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"bytes"
+	"github.com/spf13/viper"
+	"testing"
+)
+
+func TestInitByViper_Twepoch(t *testing.T) {
+	cases := []struct {
+		yaml   string
+		millis int64
+		ok     bool
+	}{
+		{"mode: 1\nsnowflake:\n  workerId: 1\n", 0, true},
+		{"mode: 1\nsnowflake:\n  workerId: 1\n  twepoch: 1603509071000\n", 1603509071000, true},
+		{"mode: 1\nsnowflake:\n  workerId: 1\n  twepoch: 2020-10-24T11:11:11+08:00\n", 1603509071000, true},
+		{"mode: 1\nsnowflake:\n  workerId: 1\n  twepoch: \"2020-10-24T03:11:11Z\"\n", 1603509071000, true},
+		{"mode: 1\nsnowflake:\n  workerId: 1\n  twepoch: 2020/10/24\n", 0, false},
+	}
+	for _, c := range cases {
+		Global = Config{}
+		v := viper.New()
+		v.SetConfigType("yaml")
+		if err := v.ReadConfig(bytes.NewBufferString(c.yaml)); err != nil {
+			t.Fatal(err)
+		}
+		err := InitByViper(v)
+		if (err == nil) != c.ok {
+			t.Fatalf("yaml:%q, expect ok:%v, err:%v", c.yaml, c.ok, err)
+		}
+		if err == nil && Global.Snowflake.TwepochMillis != c.millis {
+			t.Fatalf("yaml:%q, expect %d, got %d", c.yaml, c.millis, Global.Snowflake.TwepochMillis)
+		}
+	}
+}
+
+func TestInitByViper_WorkerIdProvider(t *testing.T) {
+	cases := []struct {
+		yaml     string
+		provider int
+		ok       bool
+	}{
+		{"mode: 1\nsnowflake:\n  workerId: -1\nzookeeper:\n  address: localhost:2181\n", WorkerIdProvider_Zookeeper, true},
+		{"mode: 1\nsnowflake:\n  workerId: -1\n  workerIdProvider: 2\netcd:\n  endpoints: [\"localhost:2379\"]\n", WorkerIdProvider_Etcd, true},
+		{"mode: 1\nsnowflake:\n  workerId: -1\n  workerIdProvider: 3\ndb:\n  type: 4\n", WorkerIdProvider_DB, true},
+		{"mode: 1\nsnowflake:\n  workerId: -1\n  workerIdProvider: 4\nk8s:\n  source: 2\n", WorkerIdProvider_K8s, true},
+		{"mode: 1\nsnowflake:\n  workerId: -1\n  workerIdProvider: 100\n", 0, false},
+	}
+	for _, c := range cases {
+		Global = Config{}
+		v := viper.New()
+		v.SetConfigType("yaml")
+		if err := v.ReadConfig(bytes.NewBufferString(c.yaml)); err != nil {
+			t.Fatal(err)
+		}
+		err := InitByViper(v)
+		if (err == nil) != c.ok {
+			t.Fatalf("yaml:%q, expect ok:%v, err:%v", c.yaml, c.ok, err)
+		}
+		if err == nil && Global.Snowflake.WorkerIdProvider != c.provider {
+			t.Fatalf("yaml:%q, expect provider %d, got %d", c.yaml, c.provider, Global.Snowflake.WorkerIdProvider)
+		}
+	}
+	// etcd的其它配置项
+	Global = Config{}
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewBufferString(cases[1].yaml + "  leaseTTL: 5\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitByViper(v); err != nil {
+		t.Fatal(err)
+	}
+	if len(Global.Etcd.Endpoints) != 1 || Global.Etcd.LeaseTTL != 5 {
+		t.Fatalf("unexpected etcd config:%+v", Global.Etcd)
+	}
+}
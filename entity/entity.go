@@ -1,7 +1,37 @@
 package entity
 
+import "time"
+
 type Segment struct {
 	Key   string
 	Step  int64
 	MaxId int64
 }
+
+// leaf_alloc 表的一行，segment模式的业务标识
+type Tag struct {
+	Key         string // biz_tag
+	MaxId       int64
+	Step        int64
+	Description string
+}
+
+// snowflake id 的各组成部分
+type IdInfo struct {
+	Id           int64
+	Timestamp    int64 // 毫秒
+	Time         time.Time
+	DatacenterId int64
+	WorkerId     int64
+	Sequence     int64
+}
+
+// leaf_worker 表的一行，snowflake workerId 的分配记录
+type Worker struct {
+	WorkerId      int64
+	Endpoint      string // 持有者的 ip:port
+	LeaseExpire   int64  // 租约到期时间，毫秒，过期后可被其它节点接管
+	LastTimestamp int64  // 上报的已使用最大时间戳，毫秒
+	Twepoch       int64
+	Version       int64 // 每次更新加1，用于乐观锁
+}
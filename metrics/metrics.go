@@ -0,0 +1,154 @@
+// prometheus指标，通过 http server 的 /metrics 暴露
+// segment模式下的key标签为biz_tag，snowflake模式下和请求了不存在的key时为空，避免标签数量不受控制
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const namespace = "leaf"
+
+// mode 标签的取值
+const (
+	ModeSegment   = "segment"
+	ModeSnowflake = "snowflake"
+)
+
+// IdErrors 的 type 标签的取值
+const (
+	ErrorUnknownKey       = "unknown_key"
+	ErrorInvalidCount     = "invalid_count"
+	ErrorClosed           = "closed"
+	ErrorRepo             = "repo"               // 初始化segment时访问repo失败
+	ErrorSegmentsNotReady = "segments_not_ready" // 当前segment已用完，下一个尚未加载完成
+	ErrorSegmentExhausted = "segment_exhausted"
+	ErrorUnhealthy        = "unhealthy" // 无法确认workerId仍归本节点所有
+	ErrorClockBackwards   = "clock_backwards"
+	ErrorOverflow         = "timestamp_overflow"
+)
+
+var Registry = prometheus.NewRegistry()
+
+var (
+	IdsIssued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ids_issued_total",
+		Help:      "Number of ids issued.",
+	}, []string{"mode", "key"})
+	IdErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "id_errors_total",
+		Help:      "Number of failed id requests by error type.",
+	}, []string{"mode", "key", "type"})
+
+	SegmentSwitches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "segment_switches_total",
+		Help:      "Number of switches to the preloaded segment.",
+	}, []string{"key"})
+	SegmentNotReady = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "segment_not_ready_total",
+		Help:      "Number of requests failed synchronously because all segments were not ready.",
+	}, []string{"key"})
+	SegmentStep = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "segment_step",
+		Help:      "Current step of the segment buffer.",
+	}, []string{"key"})
+
+	RepoCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "repo_call_duration_seconds",
+		Help:      "Latency of repo calls.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"op", "result"})
+
+	SnowflakeClockEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "snowflake_clock_events_total",
+		Help:      "Number of clock rollback events by outcome: backwards, waited, borrowed, worker_switched, failed.",
+	}, []string{"event"})
+	SnowflakeSequenceExhausted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "snowflake_sequence_exhausted_total",
+		Help:      "Number of waits for the next millisecond after the sequence was exhausted.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		IdsIssued, IdErrors,
+		SegmentSwitches, SegmentNotReady, SegmentStep, segmentRunwayCollector{},
+		RepoCallDuration,
+		SnowflakeClockEvents, SnowflakeSequenceExhausted,
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// segment模式下某个key已缓冲的id数量，及按当前速度可以使用的秒数，repo不可用时能支撑多久
+type SegmentRunway struct {
+	Key         string
+	BufferedIds int64
+	Seconds     float64 // 没有消耗无法估计时小于0，不输出该指标
+}
+
+var (
+	segmentRunway            atomic.Value // func() []SegmentRunway
+	segmentBufferedIdsDesc   = prometheus.NewDesc(namespace+"_segment_buffered_ids", "Number of ids buffered in loaded segments.", []string{"key"}, nil)
+	segmentRunwaySecondsDesc = prometheus.NewDesc(namespace+"_segment_runway_seconds", "Seconds the buffered ids will last at the consumption rate of the current segment.", []string{"key"}, nil)
+)
+
+// 采集时调用f计算，由segment模式的IdGenerator设置
+func SetSegmentRunwayFunc(f func() []SegmentRunway) {
+	segmentRunway.Store(f)
+}
+
+type segmentRunwayCollector struct{}
+
+func (segmentRunwayCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- segmentBufferedIdsDesc
+	ch <- segmentRunwaySecondsDesc
+}
+
+func (segmentRunwayCollector) Collect(ch chan<- prometheus.Metric) {
+	f, _ := segmentRunway.Load().(func() []SegmentRunway)
+	if f == nil {
+		return
+	}
+	for _, r := range f() {
+		ch <- prometheus.MustNewConstMetric(segmentBufferedIdsDesc, prometheus.GaugeValue, float64(r.BufferedIds), r.Key)
+		if r.Seconds >= 0 {
+			ch <- prometheus.MustNewConstMetric(segmentRunwaySecondsDesc, prometheus.GaugeValue, r.Seconds, r.Key)
+		}
+	}
+}
+
+// 记录一次repo调用的耗时，op 为方法名
+func ObserveRepoCall(op string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	RepoCallDuration.WithLabelValues(op, result).Observe(time.Since(start).Seconds())
+}
+
+// repo中删除key后，删除该key的所有指标
+func DeleteKey(key string) {
+	labels := prometheus.Labels{"key": key}
+	IdsIssued.DeletePartialMatch(labels)
+	IdErrors.DeletePartialMatch(labels)
+	SegmentSwitches.DeletePartialMatch(labels)
+	SegmentNotReady.DeletePartialMatch(labels)
+	SegmentStep.DeletePartialMatch(labels)
+}
@@ -0,0 +1,413 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        (unknown)
+// source: pb/leaf.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GenRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GenRequest) Reset() {
+	*x = GenRequest{}
+	mi := &file_pb_leaf_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GenRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GenRequest) ProtoMessage() {}
+
+func (x *GenRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_leaf_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GenRequest.ProtoReflect.Descriptor instead.
+func (*GenRequest) Descriptor() ([]byte, []int) {
+	return file_pb_leaf_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GenRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+type GenResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GenResponse) Reset() {
+	*x = GenResponse{}
+	mi := &file_pb_leaf_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GenResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GenResponse) ProtoMessage() {}
+
+func (x *GenResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_leaf_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GenResponse.ProtoReflect.Descriptor instead.
+func (*GenResponse) Descriptor() ([]byte, []int) {
+	return file_pb_leaf_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GenResponse) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type GenBatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Count         int32                  `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GenBatchRequest) Reset() {
+	*x = GenBatchRequest{}
+	mi := &file_pb_leaf_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GenBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GenBatchRequest) ProtoMessage() {}
+
+func (x *GenBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_leaf_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GenBatchRequest.ProtoReflect.Descriptor instead.
+func (*GenBatchRequest) Descriptor() ([]byte, []int) {
+	return file_pb_leaf_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GenBatchRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *GenBatchRequest) GetCount() int32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type GenBatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ids           []int64                `protobuf:"varint,1,rep,packed,name=ids,proto3" json:"ids,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GenBatchResponse) Reset() {
+	*x = GenBatchResponse{}
+	mi := &file_pb_leaf_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GenBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GenBatchResponse) ProtoMessage() {}
+
+func (x *GenBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_leaf_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GenBatchResponse.ProtoReflect.Descriptor instead.
+func (*GenBatchResponse) Descriptor() ([]byte, []int) {
+	return file_pb_leaf_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GenBatchResponse) GetIds() []int64 {
+	if x != nil {
+		return x.Ids
+	}
+	return nil
+}
+
+type DecodeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DecodeRequest) Reset() {
+	*x = DecodeRequest{}
+	mi := &file_pb_leaf_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DecodeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DecodeRequest) ProtoMessage() {}
+
+func (x *DecodeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_leaf_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DecodeRequest.ProtoReflect.Descriptor instead.
+func (*DecodeRequest) Descriptor() ([]byte, []int) {
+	return file_pb_leaf_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *DecodeRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type DecodeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Timestamp     int64                  `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"` // 毫秒
+	WorkerId      int64                  `protobuf:"varint,3,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	Sequence      int64                  `protobuf:"varint,4,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	DatacenterId  int64                  `protobuf:"varint,5,opt,name=datacenter_id,json=datacenterId,proto3" json:"datacenter_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DecodeResponse) Reset() {
+	*x = DecodeResponse{}
+	mi := &file_pb_leaf_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DecodeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DecodeResponse) ProtoMessage() {}
+
+func (x *DecodeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_leaf_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DecodeResponse.ProtoReflect.Descriptor instead.
+func (*DecodeResponse) Descriptor() ([]byte, []int) {
+	return file_pb_leaf_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DecodeResponse) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *DecodeResponse) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *DecodeResponse) GetWorkerId() int64 {
+	if x != nil {
+		return x.WorkerId
+	}
+	return 0
+}
+
+func (x *DecodeResponse) GetSequence() int64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *DecodeResponse) GetDatacenterId() int64 {
+	if x != nil {
+		return x.DatacenterId
+	}
+	return 0
+}
+
+var File_pb_leaf_proto protoreflect.FileDescriptor
+
+const file_pb_leaf_proto_rawDesc = "" +
+	"\n" +
+	"\rpb/leaf.proto\x12\x04leaf\"\x1e\n" +
+	"\n" +
+	"GenRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\"\x1d\n" +
+	"\vGenResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\"9\n" +
+	"\x0fGenBatchRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05count\x18\x02 \x01(\x05R\x05count\"$\n" +
+	"\x10GenBatchResponse\x12\x10\n" +
+	"\x03ids\x18\x01 \x03(\x03R\x03ids\"\x1f\n" +
+	"\rDecodeRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\"\x9c\x01\n" +
+	"\x0eDecodeResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x1c\n" +
+	"\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\x12\x1b\n" +
+	"\tworker_id\x18\x03 \x01(\x03R\bworkerId\x12\x1a\n" +
+	"\bsequence\x18\x04 \x01(\x03R\bsequence\x12#\n" +
+	"\rdatacenter_id\x18\x05 \x01(\x03R\fdatacenterId2\xa2\x01\n" +
+	"\x04Leaf\x12*\n" +
+	"\x03Gen\x12\x10.leaf.GenRequest\x1a\x11.leaf.GenResponse\x129\n" +
+	"\bGenBatch\x12\x15.leaf.GenBatchRequest\x1a\x16.leaf.GenBatchResponse\x123\n" +
+	"\x06Decode\x12\x13.leaf.DecodeRequest\x1a\x14.leaf.DecodeResponseB$Z\"github.com/longyufei109/leaf-go/pbb\x06proto3"
+
+var (
+	file_pb_leaf_proto_rawDescOnce sync.Once
+	file_pb_leaf_proto_rawDescData []byte
+)
+
+func file_pb_leaf_proto_rawDescGZIP() []byte {
+	file_pb_leaf_proto_rawDescOnce.Do(func() {
+		file_pb_leaf_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_pb_leaf_proto_rawDesc), len(file_pb_leaf_proto_rawDesc)))
+	})
+	return file_pb_leaf_proto_rawDescData
+}
+
+var file_pb_leaf_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_pb_leaf_proto_goTypes = []any{
+	(*GenRequest)(nil),       // 0: leaf.GenRequest
+	(*GenResponse)(nil),      // 1: leaf.GenResponse
+	(*GenBatchRequest)(nil),  // 2: leaf.GenBatchRequest
+	(*GenBatchResponse)(nil), // 3: leaf.GenBatchResponse
+	(*DecodeRequest)(nil),    // 4: leaf.DecodeRequest
+	(*DecodeResponse)(nil),   // 5: leaf.DecodeResponse
+}
+var file_pb_leaf_proto_depIdxs = []int32{
+	0, // 0: leaf.Leaf.Gen:input_type -> leaf.GenRequest
+	2, // 1: leaf.Leaf.GenBatch:input_type -> leaf.GenBatchRequest
+	4, // 2: leaf.Leaf.Decode:input_type -> leaf.DecodeRequest
+	1, // 3: leaf.Leaf.Gen:output_type -> leaf.GenResponse
+	3, // 4: leaf.Leaf.GenBatch:output_type -> leaf.GenBatchResponse
+	5, // 5: leaf.Leaf.Decode:output_type -> leaf.DecodeResponse
+	3, // [3:6] is the sub-list for method output_type
+	0, // [0:3] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_pb_leaf_proto_init() }
+func file_pb_leaf_proto_init() {
+	if File_pb_leaf_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pb_leaf_proto_rawDesc), len(file_pb_leaf_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_pb_leaf_proto_goTypes,
+		DependencyIndexes: file_pb_leaf_proto_depIdxs,
+		MessageInfos:      file_pb_leaf_proto_msgTypes,
+	}.Build()
+	File_pb_leaf_proto = out.File
+	file_pb_leaf_proto_goTypes = nil
+	file_pb_leaf_proto_depIdxs = nil
+}
@@ -0,0 +1,203 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: pb/leaf.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Leaf_Gen_FullMethodName      = "/leaf.Leaf/Gen"
+	Leaf_GenBatch_FullMethodName = "/leaf.Leaf/GenBatch"
+	Leaf_Decode_FullMethodName   = "/leaf.Leaf/Decode"
+)
+
+// LeafClient is the client API for Leaf service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// 生成代码：protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative pb/leaf.proto
+type LeafClient interface {
+	Gen(ctx context.Context, in *GenRequest, opts ...grpc.CallOption) (*GenResponse, error)
+	GenBatch(ctx context.Context, in *GenBatchRequest, opts ...grpc.CallOption) (*GenBatchResponse, error)
+	// 解析id，只有snowflake模式支持
+	Decode(ctx context.Context, in *DecodeRequest, opts ...grpc.CallOption) (*DecodeResponse, error)
+}
+
+type leafClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLeafClient(cc grpc.ClientConnInterface) LeafClient {
+	return &leafClient{cc}
+}
+
+func (c *leafClient) Gen(ctx context.Context, in *GenRequest, opts ...grpc.CallOption) (*GenResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GenResponse)
+	err := c.cc.Invoke(ctx, Leaf_Gen_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *leafClient) GenBatch(ctx context.Context, in *GenBatchRequest, opts ...grpc.CallOption) (*GenBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GenBatchResponse)
+	err := c.cc.Invoke(ctx, Leaf_GenBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *leafClient) Decode(ctx context.Context, in *DecodeRequest, opts ...grpc.CallOption) (*DecodeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DecodeResponse)
+	err := c.cc.Invoke(ctx, Leaf_Decode_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LeafServer is the server API for Leaf service.
+// All implementations must embed UnimplementedLeafServer
+// for forward compatibility.
+//
+// 生成代码：protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative pb/leaf.proto
+type LeafServer interface {
+	Gen(context.Context, *GenRequest) (*GenResponse, error)
+	GenBatch(context.Context, *GenBatchRequest) (*GenBatchResponse, error)
+	// 解析id，只有snowflake模式支持
+	Decode(context.Context, *DecodeRequest) (*DecodeResponse, error)
+	mustEmbedUnimplementedLeafServer()
+}
+
+// UnimplementedLeafServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLeafServer struct{}
+
+func (UnimplementedLeafServer) Gen(context.Context, *GenRequest) (*GenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Gen not implemented")
+}
+func (UnimplementedLeafServer) GenBatch(context.Context, *GenBatchRequest) (*GenBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GenBatch not implemented")
+}
+func (UnimplementedLeafServer) Decode(context.Context, *DecodeRequest) (*DecodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Decode not implemented")
+}
+func (UnimplementedLeafServer) mustEmbedUnimplementedLeafServer() {}
+func (UnimplementedLeafServer) testEmbeddedByValue()              {}
+
+// UnsafeLeafServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LeafServer will
+// result in compilation errors.
+type UnsafeLeafServer interface {
+	mustEmbedUnimplementedLeafServer()
+}
+
+func RegisterLeafServer(s grpc.ServiceRegistrar, srv LeafServer) {
+	// If the following call pancis, it indicates UnimplementedLeafServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Leaf_ServiceDesc, srv)
+}
+
+func _Leaf_Gen_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LeafServer).Gen(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Leaf_Gen_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LeafServer).Gen(ctx, req.(*GenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Leaf_GenBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GenBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LeafServer).GenBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Leaf_GenBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LeafServer).GenBatch(ctx, req.(*GenBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Leaf_Decode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DecodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LeafServer).Decode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Leaf_Decode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LeafServer).Decode(ctx, req.(*DecodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Leaf_ServiceDesc is the grpc.ServiceDesc for Leaf service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Leaf_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "leaf.Leaf",
+	HandlerType: (*LeafServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Gen",
+			Handler:    _Leaf_Gen_Handler,
+		},
+		{
+			MethodName: "GenBatch",
+			Handler:    _Leaf_GenBatch_Handler,
+		},
+		{
+			MethodName: "Decode",
+			Handler:    _Leaf_Decode_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "pb/leaf.proto",
+}
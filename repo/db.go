@@ -2,17 +2,24 @@ package repo
 
 import (
 	"database/sql"
+	"embed"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
 	"github.com/longyufei109/leaf-go/config"
 	"github.com/longyufei109/leaf-go/entity"
 	"github.com/longyufei109/leaf-go/log"
+	"github.com/longyufei109/leaf-go/metrics"
+	_ "modernc.org/sqlite"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 )
 
-//DROP TABLE IF EXISTS `leaf_alloc`;
+// 各数据库的建表语句见 schema/ 目录，MySQL:
 //
-//CREATE TABLE `leaf_alloc` (
+//CREATE TABLE IF NOT EXISTS `leaf_alloc` (
 //`biz_tag` varchar(128)  NOT NULL DEFAULT '',
 //`max_id` bigint(20) NOT NULL DEFAULT '1',
 //`step` int(11) NOT NULL,
@@ -21,22 +28,52 @@ import (
 //PRIMARY KEY (`biz_tag`)
 //) ENGINE=InnoDB;
 
+//go:embed schema/*.sql
+var schemaFS embed.FS
+
+// 不同数据库在驱动、建表语句和更新方式上的差异
+type dialect struct {
+	driver       string
+	schema       string // schema/ 目录下的建表语句文件
+	returning    bool   // 支持 UPDATE ... RETURNING，一条语句完成更新和查询，不需要事务
+	dollar       bool   // 占位符为 $1,$2... 而不是 ?
+	maxOpenConns int    // 0 表示不限制
+}
+
+var dialects = map[int]dialect{
+	config.DB_Type_MySQL:    {driver: "mysql", schema: "schema/mysql.sql"},
+	config.DB_Type_Postgres: {driver: "postgres", schema: "schema/postgres.sql", returning: true, dollar: true},
+	// sqlite同一时间只允许一个写事务，限制为单连接以避免 SQLITE_BUSY
+	config.DB_Type_SQLite: {driver: "sqlite", schema: "schema/sqlite.sql", maxOpenConns: 1},
+}
+
 type dbImpl struct {
-	db    []*sql.DB
-	pos   int32
-	total int32
+	dialect dialect
+	db      []*sql.DB
+	pos     int32
+	total   int32
 }
 
-func newDBRepo() (Repo, error) {
+func newDBRepo(d dialect) (Repo, error) {
 	var err error
-	r := &dbImpl{}
+	r := &dbImpl{dialect: d}
 	for _, datasource := range config.Global.DB.DataSource {
-		db, e := sql.Open("mysql", datasource)
-		if e == nil {
-			r.db = append(r.db, db)
-		} else {
-			log.Print("open db failed. datasource:%s, err:%v", datasource, err)
+		db, e := sql.Open(d.driver, datasource)
+		if e != nil {
+			log.Print("open db failed. datasource:%s, err:%v", datasource, e)
+			continue
 		}
+		if d.maxOpenConns > 0 {
+			db.SetMaxOpenConns(d.maxOpenConns)
+		}
+		if config.Global.DB.AutoCreateTable {
+			if e = r.createTable(db); e != nil {
+				log.Print("create table failed. datasource:%s, err:%v", datasource, e)
+				_ = db.Close()
+				continue
+			}
+		}
+		r.db = append(r.db, db)
 	}
 	if len(r.db) == 0 {
 		err = fmt.Errorf("no valid db")
@@ -47,6 +84,23 @@ func newDBRepo() (Repo, error) {
 	return r, err
 }
 
+// 逐条执行，mysql驱动默认不支持一次执行多条语句
+func (r *dbImpl) createTable(db *sql.DB) error {
+	data, err := schemaFS.ReadFile(r.dialect.schema)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range strings.Split(string(data), ";") {
+		if stmt = strings.TrimSpace(stmt); stmt == "" {
+			continue
+		}
+		if _, err = db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *dbImpl) getDB() *sql.DB {
 	pos := atomic.AddInt32(&r.pos, 1) & r.total
 	if pos == r.total {
@@ -55,12 +109,30 @@ func (r *dbImpl) getDB() *sql.DB {
 	return r.db[pos]
 }
 
-func (r *dbImpl) GetAllKeys() ([]string, error) {
+// 将 ? 占位符替换为当前数据库支持的格式
+func (r *dbImpl) rebind(query string) string {
+	if !r.dialect.dollar {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func (r *dbImpl) GetAllKeys() (keys []string, err error) {
+	defer func(start time.Time) { metrics.ObserveRepoCall("GetAllKeys", start, err) }(time.Now())
 	rows, err := r.getDB().Query("SELECT biz_tag FROM leaf_alloc")
 	if err != nil {
 		return nil, err
 	}
-	var keys []string
 	for rows.Next() {
 		key := ""
 		_ = rows.Scan(&key)
@@ -73,50 +145,45 @@ func (r *dbImpl) GetAllKeys() ([]string, error) {
 }
 
 func (r *dbImpl) UpdateMaxIdAndGetSegment(key string) (seg entity.Segment, err error) {
-	seg.Key = key
-	var tx *sql.Tx
-	tx, err = r.getDB().Begin()
-	if err != nil {
-		return
-	}
-	_, err = tx.Exec("UPDATE leaf_alloc SET max_id=max_id+step WHERE biz_tag=?", key)
-	if err != nil {
-		_ = tx.Rollback()
-		return
-	}
-
-	row := tx.QueryRow("SELECT max_id,step FROM leaf_alloc WHERE biz_tag=?", key)
-	if row == nil {
-		err = fmt.Errorf("not found, key:%s", key)
-		_ = tx.Rollback()
-		return
-	}
-	err = row.Scan(&seg.MaxId, &seg.Step)
-	_ = tx.Commit()
-	return
+	defer func(start time.Time) { metrics.ObserveRepoCall("UpdateMaxIdAndGetSegment", start, err) }(time.Now())
+	return r.updateMaxId(key, "UPDATE leaf_alloc SET max_id=max_id+step, update_time=CURRENT_TIMESTAMP WHERE biz_tag=?", key)
 }
 
 func (r *dbImpl) UpdateMaxIdByStepAndGetSegment(key string, step int64) (seg entity.Segment, err error) {
+	defer func(start time.Time) { metrics.ObserveRepoCall("UpdateMaxIdByStepAndGetSegment", start, err) }(time.Now())
+	return r.updateMaxId(key, "UPDATE leaf_alloc SET max_id=max_id+?, update_time=CURRENT_TIMESTAMP WHERE biz_tag=?", step, key)
+}
+
+// 原子地执行update，并返回更新后的max_id和step
+func (r *dbImpl) updateMaxId(key string, update string, args ...interface{}) (seg entity.Segment, err error) {
 	seg.Key = key
+	if r.dialect.returning {
+		row := r.getDB().QueryRow(r.rebind(update+" RETURNING max_id,step"), args...)
+		if err = row.Scan(&seg.MaxId, &seg.Step); err == sql.ErrNoRows {
+			err = fmt.Errorf("not found, key:%s", key)
+		}
+		return
+	}
+
 	var tx *sql.Tx
 	tx, err = r.getDB().Begin()
 	if err != nil {
 		return
 	}
-
-	_, err = tx.Exec("UPDATE leaf_alloc SET max_id=max_id+? WHERE biz_tag=?", step, key)
+	_, err = tx.Exec(r.rebind(update), args...)
 	if err != nil {
 		_ = tx.Rollback()
 		return
 	}
 
-	row := tx.QueryRow("SELECT max_id,step FROM leaf_alloc WHERE biz_tag=?", key)
-	if row == nil {
-		err = fmt.Errorf("not found, key:%s", key)
+	row := tx.QueryRow(r.rebind("SELECT max_id,step FROM leaf_alloc WHERE biz_tag=?"), key)
+	if err = row.Scan(&seg.MaxId, &seg.Step); err != nil {
+		if err == sql.ErrNoRows {
+			err = fmt.Errorf("not found, key:%s", key)
+		}
 		_ = tx.Rollback()
 		return
 	}
-	err = row.Scan(&seg.MaxId, &seg.Step)
-	_ = tx.Commit()
+	err = tx.Commit()
 	return
 }
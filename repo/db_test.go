@@ -0,0 +1,104 @@
+package repo
+
+import (
+	"github.com/longyufei109/leaf-go/config"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func newTestSQLiteRepo(t *testing.T) Repo {
+	config.Global.DB = config.DBConfig{
+		Type:            config.DB_Type_SQLite,
+		DataSource:      []string{"file:" + filepath.Join(t.TempDir(), "leaf.db")},
+		AutoCreateTable: true,
+	}
+	r, err := NewRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.(*dbImpl).getDB().Exec("INSERT INTO leaf_alloc(biz_tag, max_id, step, description) VALUES('test', 1, 100, 'test')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestDbImpl_Rebind(t *testing.T) {
+	r := &dbImpl{dialect: dialects[config.DB_Type_Postgres]}
+	q := r.rebind("UPDATE leaf_alloc SET max_id=max_id+? WHERE biz_tag=?")
+	if q != "UPDATE leaf_alloc SET max_id=max_id+$1 WHERE biz_tag=$2" {
+		t.Fatalf("unexpected query:%s", q)
+	}
+}
+
+func TestDbImpl_SQLite(t *testing.T) {
+	r := newTestSQLiteRepo(t)
+
+	keys, err := r.GetAllKeys()
+	if err != nil || len(keys) != 1 || keys[0] != "test" {
+		t.Fatalf("unexpected keys:%v, err:%v", keys, err)
+	}
+	seg, err := r.UpdateMaxIdAndGetSegment("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seg.MaxId != 101 || seg.Step != 100 {
+		t.Fatalf("unexpected segment:%+v", seg)
+	}
+	seg, err = r.UpdateMaxIdByStepAndGetSegment("test", 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seg.MaxId != 401 || seg.Step != 100 {
+		t.Fatalf("unexpected segment:%+v", seg)
+	}
+	if _, err = r.UpdateMaxIdAndGetSegment("not_exist"); err == nil {
+		t.Fatal("expect error for unknown key")
+	}
+}
+
+func TestDbImpl_SQLiteConcurrently(t *testing.T) {
+	r := newTestSQLiteRepo(t)
+
+	var mu sync.Mutex
+	seen := map[int64]bool{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				seg, err := r.UpdateMaxIdAndGetSegment("test")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				if seen[seg.MaxId] {
+					t.Errorf("duplicate max_id:%d", seg.MaxId)
+				}
+				seen[seg.MaxId] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// sqlite 3.35+ 同样支持 UPDATE ... RETURNING，借此覆盖postgres的更新路径
+func TestDbImpl_Returning(t *testing.T) {
+	r := newTestSQLiteRepo(t).(*dbImpl)
+	r.dialect.returning = true
+
+	seg, err := r.UpdateMaxIdByStepAndGetSegment("test", 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seg.MaxId != 51 || seg.Step != 100 {
+		t.Fatalf("unexpected segment:%+v", seg)
+	}
+	if _, err = r.UpdateMaxIdAndGetSegment("not_exist"); err == nil {
+		t.Fatal("expect error for unknown key")
+	}
+}
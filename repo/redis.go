@@ -0,0 +1,241 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/entity"
+	"github.com/longyufei109/leaf-go/log"
+	"github.com/longyufei109/leaf-go/metrics"
+	"github.com/redis/go-redis/v9"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 每个biz_tag对应一个hash，key为 leaf_alloc:{biz_tag}，字段与leaf_alloc表一致:
+//   max_id, step, description, update_time(毫秒)
+// 所有biz_tag记录在set leaf_alloc:tags 中
+//
+// 新增biz_tag(也可以通过 Repo.CreateTag):
+//   HSET leaf_alloc:test max_id 1 step 1000 description "test"
+//   SADD leaf_alloc:tags test
+
+const (
+	redisKeyPrefix   = "leaf_alloc:"
+	redisTagsKey     = redisKeyPrefix + "tags"
+	redisTagsChannel = redisKeyPrefix + "changed" // biz_tag变化时发布，消息为biz_tag
+)
+
+// KEYS[1]: biz_tag对应的hash
+// ARGV[1]: 步长，为空时使用hash中的step
+// ARGV[2]: 当前时间，毫秒
+// 返回 {max_id, step}，biz_tag不存在时返回nil
+var updateMaxIdScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return false
+end
+local step = redis.call('HGET', KEYS[1], 'step')
+local incr = ARGV[1]
+if incr == '' then
+	incr = step
+end
+local max = redis.call('HINCRBY', KEYS[1], 'max_id', incr)
+redis.call('HSET', KEYS[1], 'update_time', ARGV[2])
+return {max, tonumber(step)}
+`)
+
+type redisImpl struct {
+	client *redis.Client
+}
+
+// 只支持一个redis，多个独立的redis各自记录max_id，会分配出重复的segment
+// 高可用使用redis自身的主从切换
+func newRedisRepo() (Repo, error) {
+	datasources := config.Global.DB.DataSource
+	if len(datasources) != 1 {
+		return nil, fmt.Errorf("redis repo requires exactly one datasource, got %d", len(datasources))
+	}
+	opt, err := redis.ParseURL(datasources[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse redis url failed. datasource:%s, err:%v", datasources[0], err)
+	}
+	return &redisImpl{client: redis.NewClient(opt)}, nil
+}
+
+func (r *redisImpl) GetAllKeys() (keys []string, err error) {
+	defer func(start time.Time) { metrics.ObserveRepoCall("GetAllKeys", start, err) }(time.Now())
+	return r.client.SMembers(context.Background(), redisTagsKey).Result()
+}
+
+func (r *redisImpl) UpdateMaxIdAndGetSegment(key string) (seg entity.Segment, err error) {
+	defer func(start time.Time) { metrics.ObserveRepoCall("UpdateMaxIdAndGetSegment", start, err) }(time.Now())
+	return r.updateMaxId(key, "")
+}
+
+func (r *redisImpl) UpdateMaxIdByStepAndGetSegment(key string, step int64) (seg entity.Segment, err error) {
+	defer func(start time.Time) { metrics.ObserveRepoCall("UpdateMaxIdByStepAndGetSegment", start, err) }(time.Now())
+	return r.updateMaxId(key, step)
+}
+
+func (r *redisImpl) updateMaxId(key string, step interface{}) (seg entity.Segment, err error) {
+	seg.Key = key
+	now := time.Now().UnixNano() / 1e6
+	res, err := updateMaxIdScript.Run(context.Background(), r.client, []string{redisKeyPrefix + key}, step, now).Int64Slice()
+	if err == redis.Nil {
+		err = fmt.Errorf("not found, key:%s", key)
+		return
+	}
+	if err != nil {
+		return
+	}
+	seg.MaxId, seg.Step = res[0], res[1]
+	return
+}
+
+// KEYS[1]: biz_tag对应的hash  KEYS[2]: leaf_alloc:tags
+// ARGV: biz_tag, max_id, step, description, 当前时间(毫秒)
+// 已存在时返回0
+var createTagScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'max_id', ARGV[2], 'step', ARGV[3], 'description', ARGV[4], 'update_time', ARGV[5])
+redis.call('SADD', KEYS[2], ARGV[1])
+return 1
+`)
+
+// KEYS[1]: biz_tag对应的hash
+// ARGV: 字段名, 字段值, 当前时间(毫秒)
+// 不存在时返回0
+var updateTagScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2], 'update_time', ARGV[3])
+return 1
+`)
+
+// KEYS[1]: biz_tag对应的hash  KEYS[2]: leaf_alloc:tags
+// ARGV[1]: biz_tag
+// 返回删除的hash数量
+var deleteTagScript = redis.NewScript(`
+redis.call('SREM', KEYS[2], ARGV[1])
+return redis.call('DEL', KEYS[1])
+`)
+
+func (r *redisImpl) GetAllTags() (tags []entity.Tag, err error) {
+	defer func(start time.Time) { metrics.ObserveRepoCall("GetAllTags", start, err) }(time.Now())
+	client := r.client
+	ctx := context.Background()
+	keys, err := client.SMembers(ctx, redisTagsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	cmds := make([]*redis.MapStringStringCmd, len(keys))
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.HGetAll(ctx, redisKeyPrefix+key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	tags = make([]entity.Tag, 0, len(keys))
+	for i, key := range keys {
+		fields := cmds[i].Val()
+		if len(fields) == 0 { // 只在set中，hash已删除
+			continue
+		}
+		tag := entity.Tag{Key: key, Description: fields["description"]}
+		tag.MaxId, _ = strconv.ParseInt(fields["max_id"], 10, 64)
+		tag.Step, _ = strconv.ParseInt(fields["step"], 10, 64)
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+func (r *redisImpl) CreateTag(tag entity.Tag) error {
+	ok, err := createTagScript.Run(context.Background(), r.client, []string{redisKeyPrefix + tag.Key, redisTagsKey},
+		tag.Key, tag.MaxId, tag.Step, tag.Description, time.Now().UnixNano()/1e6).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrTagExists
+	}
+	r.publish(tag.Key)
+	return nil
+}
+
+func (r *redisImpl) UpdateStep(key string, step int64) error {
+	if err := r.updateTag(key, "step", step); err != nil {
+		return err
+	}
+	r.publish(key)
+	return nil
+}
+
+func (r *redisImpl) UpdateDescription(key string, description string) error {
+	return r.updateTag(key, "description", description)
+}
+
+func (r *redisImpl) updateTag(key string, field string, value interface{}) error {
+	ok, err := updateTagScript.Run(context.Background(), r.client, []string{redisKeyPrefix + key},
+		field, value, time.Now().UnixNano()/1e6).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrTagNotFound
+	}
+	return nil
+}
+
+func (r *redisImpl) DeleteTag(key string) error {
+	n, err := deleteTagScript.Run(context.Background(), r.client, []string{redisKeyPrefix + key, redisTagsKey}, key).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTagNotFound
+	}
+	r.publish(key)
+	return nil
+}
+
+// 通知失败时其它节点在定时刷新时更新
+func (r *redisImpl) publish(key string) {
+	if err := r.client.Publish(context.Background(), redisTagsChannel, key).Err(); err != nil {
+		log.Print("publish biz_tag change failed. key:%s, err:%v", key, err)
+	}
+}
+
+// 实现 TagNotifier，断线后自动重连
+// 返回前等待订阅确认，之后的变化不会遗漏；确认失败时仅记录日志，由定时刷新兜底
+func (r *redisImpl) WatchTags(stop <-chan struct{}) <-chan struct{} {
+	notify := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := r.client.Subscribe(ctx, redisTagsChannel)
+	receiveCtx, receiveCancel := context.WithTimeout(ctx, 5*time.Second)
+	if _, err := sub.Receive(receiveCtx); err != nil {
+		log.Print("subscribe biz_tag change failed. err:%v", err)
+	}
+	receiveCancel()
+	go func() {
+		<-stop
+		cancel()
+		_ = sub.Close()
+	}()
+	go func() {
+		for range sub.Channel() {
+			select {
+			case notify <- struct{}{}:
+			default: // 已有未处理的通知
+			}
+		}
+	}()
+	return notify
+}
@@ -0,0 +1,98 @@
+package repo
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/longyufei109/leaf-go/config"
+	"sync"
+	"testing"
+)
+
+func newTestRedisRepo(t *testing.T) (*miniredis.Miniredis, Repo) {
+	mr := miniredis.RunT(t)
+	config.Global.DB = config.DBConfig{
+		Type:       config.DB_Type_Redis,
+		DataSource: []string{"redis://" + mr.Addr()},
+	}
+	r, err := NewRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.HSet(redisKeyPrefix+"test", "max_id", "1", "step", "100")
+	mr.SAdd(redisTagsKey, "test")
+	return mr, r
+}
+
+func TestRedisImpl_GetAllKeys(t *testing.T) {
+	_, r := newTestRedisRepo(t)
+	keys, err := r.GetAllKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "test" {
+		t.Fatalf("unexpected keys:%v", keys)
+	}
+}
+
+func TestRedisImpl_UpdateMaxId(t *testing.T) {
+	mr, r := newTestRedisRepo(t)
+
+	seg, err := r.UpdateMaxIdAndGetSegment("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seg.MaxId != 101 || seg.Step != 100 {
+		t.Fatalf("unexpected segment:%+v", seg)
+	}
+	seg, err = r.UpdateMaxIdByStepAndGetSegment("test", 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seg.MaxId != 401 || seg.Step != 100 { // step为repo中的配置值，不随传入的步长变化
+		t.Fatalf("unexpected segment:%+v", seg)
+	}
+	if mr.HGet(redisKeyPrefix+"test", "update_time") == "" {
+		t.Fatal("update_time not set")
+	}
+	if _, err = r.UpdateMaxIdAndGetSegment("not_exist"); err == nil {
+		t.Fatal("expect error for unknown key")
+	}
+}
+
+func TestRedisImpl_UpdateMaxIdConcurrently(t *testing.T) {
+	_, r := newTestRedisRepo(t)
+
+	var mu sync.Mutex
+	seen := map[int64]bool{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				seg, err := r.UpdateMaxIdAndGetSegment("test")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				if seen[seg.MaxId] {
+					t.Errorf("duplicate max_id:%d", seg.MaxId)
+				}
+				seen[seg.MaxId] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// 多个独立的redis各自记录max_id，会分配出重复的segment
+func TestRedisImpl_DataSources(t *testing.T) {
+	mr1, mr2 := miniredis.RunT(t), miniredis.RunT(t)
+	for _, datasources := range [][]string{nil, {"redis://" + mr1.Addr(), "redis://" + mr2.Addr()}, {"mysql://x"}} {
+		config.Global.DB = config.DBConfig{Type: config.DB_Type_Redis, DataSource: datasources}
+		if _, err := NewRepo(); err == nil {
+			t.Fatalf("datasources:%v, expect error", datasources)
+		}
+	}
+}
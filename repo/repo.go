@@ -1,22 +1,68 @@
 package repo
 
 import (
+	"errors"
+	"fmt"
 	"github.com/longyufei109/leaf-go/config"
 	"github.com/longyufei109/leaf-go/entity"
 )
 
+var (
+	ErrTagNotFound = errors.New("biz_tag not found")
+	ErrTagExists   = errors.New("biz_tag already exists")
+)
+
 type Repo interface {
 	GetAllKeys() ([]string, error)
 	// 原子操作
 	UpdateMaxIdAndGetSegment(key string) (entity.Segment, error)
 	// 原子操作
 	UpdateMaxIdByStepAndGetSegment(key string, step int64) (entity.Segment, error)
+
+	// biz_tag管理，见 tag.go
+	GetAllTags() ([]entity.Tag, error)
+	// 已存在时返回 ErrTagExists
+	CreateTag(tag entity.Tag) error
+	// 以下不存在时返回 ErrTagNotFound
+	UpdateStep(key string, step int64) error
+	UpdateDescription(key string, description string) error
+	DeleteTag(key string) error
+}
+
+// Repo 可选实现，biz_tag被创建、修改step或删除时通知，segment模式下收到通知后立即刷新缓存
+type TagNotifier interface {
+	// stop关闭后不再通知，多次变化可能合并为一次通知
+	WatchTags(stop <-chan struct{}) <-chan struct{}
+}
+
+// snowflake workerId 的分配记录，见 worker.go
+type WorkerRepo interface {
+	GetAllWorkers() ([]entity.Worker, error)
+	// worker_id 已存在时返回false
+	InsertWorker(w entity.Worker) (bool, error)
+	// 仅当版本号仍为 version 时更新，并将版本号加1，否则返回false
+	UpdateWorker(w entity.Worker, version int64) (bool, error)
 }
 
 func NewRepo() (Repo, error) {
 	_type := config.Global.DB.Type
-	if _type == config.DB_Type_MySQL {
-		return newDBRepo()
+	if d, ok := dialects[_type]; ok {
+		return newDBRepo(d)
+	} else if _type == config.DB_Type_Redis {
+		return newRedisRepo()
+	}
+	return nil, fmt.Errorf("not support db type:%d", _type)
+}
+
+// 仅支持关系型数据库
+func NewWorkerRepo() (WorkerRepo, error) {
+	_type := config.Global.DB.Type
+	if d, ok := dialects[_type]; ok {
+		r, err := newDBRepo(d)
+		if err != nil {
+			return nil, err
+		}
+		return r.(*dbImpl), nil
 	}
-	return nil, nil
+	return nil, fmt.Errorf("not support db type:%d for worker repo", _type)
 }
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS `leaf_alloc` (
+`biz_tag` varchar(128)  NOT NULL DEFAULT '',
+`max_id` bigint(20) NOT NULL DEFAULT '1',
+`step` int(11) NOT NULL,
+`description` varchar(256)  DEFAULT NULL,
+`update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+PRIMARY KEY (`biz_tag`)
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS `leaf_worker` (
+`worker_id` int(11) NOT NULL,
+`endpoint` varchar(128) NOT NULL DEFAULT '',
+`lease_expire` bigint(20) NOT NULL DEFAULT '0',
+`last_timestamp` bigint(20) NOT NULL DEFAULT '0',
+`twepoch` bigint(20) NOT NULL DEFAULT '0',
+`version` bigint(20) NOT NULL DEFAULT '0',
+`update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+PRIMARY KEY (`worker_id`)
+) ENGINE=InnoDB;
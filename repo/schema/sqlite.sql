@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS leaf_alloc (
+biz_tag varchar(128) NOT NULL DEFAULT '',
+max_id bigint NOT NULL DEFAULT 1,
+step integer NOT NULL,
+description varchar(256) DEFAULT NULL,
+update_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+PRIMARY KEY (biz_tag)
+);
+
+CREATE TABLE IF NOT EXISTS leaf_worker (
+worker_id integer NOT NULL,
+endpoint varchar(128) NOT NULL DEFAULT '',
+lease_expire bigint NOT NULL DEFAULT 0,
+last_timestamp bigint NOT NULL DEFAULT 0,
+twepoch bigint NOT NULL DEFAULT 0,
+version bigint NOT NULL DEFAULT 0,
+update_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+PRIMARY KEY (worker_id)
+);
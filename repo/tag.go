@@ -0,0 +1,83 @@
+package repo
+
+import (
+	"database/sql"
+	"github.com/longyufei109/leaf-go/entity"
+	"github.com/longyufei109/leaf-go/metrics"
+	"time"
+)
+
+// leaf_alloc 表中biz_tag的管理，redis的实现见 redis.go
+
+func (r *dbImpl) GetAllTags() (tags []entity.Tag, err error) {
+	defer func(start time.Time) { metrics.ObserveRepoCall("GetAllTags", start, err) }(time.Now())
+	rows, err := r.getDB().Query("SELECT biz_tag,max_id,step,description FROM leaf_alloc ORDER BY biz_tag")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tag entity.Tag
+		var description sql.NullString
+		if err = rows.Scan(&tag.Key, &tag.MaxId, &tag.Step, &description); err != nil {
+			return nil, err
+		}
+		tag.Description = description.String
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// 主键冲突的错误各驱动不同，插入失败时再查询一次判断是否已存在
+func (r *dbImpl) CreateTag(tag entity.Tag) error {
+	db := r.getDB()
+	_, err := db.Exec(r.rebind("INSERT INTO leaf_alloc(biz_tag,max_id,step,description) VALUES(?,?,?,?)"),
+		tag.Key, tag.MaxId, tag.Step, tag.Description)
+	if err == nil {
+		return nil
+	}
+	if exists, e := r.tagExists(db, tag.Key); e == nil && exists {
+		return ErrTagExists
+	}
+	return err
+}
+
+func (r *dbImpl) UpdateStep(key string, step int64) error {
+	return r.updateTag(key, "UPDATE leaf_alloc SET step=?, update_time=CURRENT_TIMESTAMP WHERE biz_tag=?", step, key)
+}
+
+func (r *dbImpl) UpdateDescription(key string, description string) error {
+	return r.updateTag(key, "UPDATE leaf_alloc SET description=?, update_time=CURRENT_TIMESTAMP WHERE biz_tag=?", description, key)
+}
+
+func (r *dbImpl) DeleteTag(key string) error {
+	return r.updateTag(key, "DELETE FROM leaf_alloc WHERE biz_tag=?", key)
+}
+
+// mysql默认返回实际修改的行数，值未变化时为0，此时再查询一次判断是否存在
+func (r *dbImpl) updateTag(key string, query string, args ...interface{}) error {
+	db := r.getDB()
+	res, err := db.Exec(r.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return nil
+	}
+	exists, err := r.tagExists(db, key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrTagNotFound
+	}
+	return nil
+}
+
+func (r *dbImpl) tagExists(db *sql.DB, key string) (bool, error) {
+	var n int
+	if err := db.QueryRow(r.rebind("SELECT COUNT(*) FROM leaf_alloc WHERE biz_tag=?"), key).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,81 @@
+package repo
+
+import (
+	"github.com/longyufei109/leaf-go/entity"
+	"testing"
+	"time"
+)
+
+// newTestSQLiteRepo 和 newTestRedisRepo 中已有biz_tag test
+func testTags(t *testing.T, r Repo) {
+	if err := r.CreateTag(entity.Tag{Key: "order", MaxId: 1000, Step: 50, Description: "order id"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CreateTag(entity.Tag{Key: "order", MaxId: 1, Step: 50}); err != ErrTagExists {
+		t.Fatalf("expect ErrTagExists, got %v", err)
+	}
+	if err := r.UpdateStep("order", 200); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.UpdateStep("order", 200); err != nil { // 值未变化
+		t.Fatal(err)
+	}
+	if err := r.UpdateDescription("order", "order no"); err != nil {
+		t.Fatal(err)
+	}
+	seg, err := r.UpdateMaxIdAndGetSegment("order")
+	if err != nil || seg.MaxId != 1200 || seg.Step != 200 {
+		t.Fatalf("unexpected segment:%+v, err:%v", seg, err)
+	}
+	tags, err := r.GetAllTags()
+	if err != nil || len(tags) != 2 {
+		t.Fatalf("unexpected tags:%+v, err:%v", tags, err)
+	}
+	if tags[0] != (entity.Tag{Key: "order", MaxId: 1200, Step: 200, Description: "order no"}) || tags[1].Key != "test" {
+		t.Fatalf("unexpected tags:%+v", tags)
+	}
+
+	if err = r.DeleteTag("order"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := r.GetAllKeys(); err != nil || len(keys) != 1 {
+		t.Fatalf("unexpected keys:%v, err:%v", keys, err)
+	}
+	for _, err = range []error{r.DeleteTag("order"), r.UpdateStep("order", 1), r.UpdateDescription("order", "")} {
+		if err != ErrTagNotFound {
+			t.Fatalf("expect ErrTagNotFound, got %v", err)
+		}
+	}
+}
+
+func TestDbImpl_Tags(t *testing.T) {
+	testTags(t, newTestSQLiteRepo(t))
+}
+
+func TestRedisImpl_Tags(t *testing.T) {
+	_, r := newTestRedisRepo(t)
+	testTags(t, r)
+}
+
+func TestRedisImpl_WatchTags(t *testing.T) {
+	_, r := newTestRedisRepo(t)
+	stop := make(chan struct{})
+	defer close(stop)
+	notify := r.(TagNotifier).WatchTags(stop) // 返回时已订阅
+
+	changes := []func() error{
+		func() error { return r.CreateTag(entity.Tag{Key: "order", MaxId: 1, Step: 50}) },
+		func() error { return r.UpdateStep("order", 100) },
+		func() error { return r.DeleteTag("order") },
+	}
+	for i, change := range changes {
+		if err := change(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-notify:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("change %d, expect notification", i)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package repo
+
+import "github.com/longyufei109/leaf-go/entity"
+
+// 建表语句见 schema/ 目录，MySQL:
+//
+//CREATE TABLE IF NOT EXISTS `leaf_worker` (
+//`worker_id` int(11) NOT NULL,
+//`endpoint` varchar(128) NOT NULL DEFAULT '',
+//`lease_expire` bigint(20) NOT NULL DEFAULT '0',
+//`last_timestamp` bigint(20) NOT NULL DEFAULT '0',
+//`twepoch` bigint(20) NOT NULL DEFAULT '0',
+//`version` bigint(20) NOT NULL DEFAULT '0',
+//`update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+//PRIMARY KEY (`worker_id`)
+//) ENGINE=InnoDB;
+
+func (r *dbImpl) GetAllWorkers() ([]entity.Worker, error) {
+	rows, err := r.getDB().Query("SELECT worker_id,endpoint,lease_expire,last_timestamp,twepoch,version FROM leaf_worker ORDER BY worker_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var workers []entity.Worker
+	for rows.Next() {
+		var w entity.Worker
+		if err = rows.Scan(&w.WorkerId, &w.Endpoint, &w.LeaseExpire, &w.LastTimestamp, &w.Twepoch, &w.Version); err != nil {
+			return nil, err
+		}
+		workers = append(workers, w)
+	}
+	return workers, rows.Err()
+}
+
+// 主键冲突的错误各驱动不同，插入失败时再查询一次判断是否已存在
+func (r *dbImpl) InsertWorker(w entity.Worker) (bool, error) {
+	db := r.getDB()
+	_, err := db.Exec(r.rebind("INSERT INTO leaf_worker(worker_id,endpoint,lease_expire,last_timestamp,twepoch,version) VALUES(?,?,?,?,?,?)"),
+		w.WorkerId, w.Endpoint, w.LeaseExpire, w.LastTimestamp, w.Twepoch, w.Version)
+	if err == nil {
+		return true, nil
+	}
+	var n int
+	if e := db.QueryRow(r.rebind("SELECT COUNT(*) FROM leaf_worker WHERE worker_id=?"), w.WorkerId).Scan(&n); e == nil && n > 0 {
+		return false, nil
+	}
+	return false, err
+}
+
+func (r *dbImpl) UpdateWorker(w entity.Worker, version int64) (bool, error) {
+	res, err := r.getDB().Exec(r.rebind("UPDATE leaf_worker SET endpoint=?, lease_expire=?, last_timestamp=?, twepoch=?, version=version+1, update_time=CURRENT_TIMESTAMP WHERE worker_id=? AND version=?"),
+		w.Endpoint, w.LeaseExpire, w.LastTimestamp, w.Twepoch, w.WorkerId, version)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
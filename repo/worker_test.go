@@ -0,0 +1,44 @@
+package repo
+
+import (
+	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/entity"
+	"path/filepath"
+	"testing"
+)
+
+func TestDbImpl_Worker(t *testing.T) {
+	config.Global.DB = config.DBConfig{
+		Type:            config.DB_Type_SQLite,
+		DataSource:      []string{"file:" + filepath.Join(t.TempDir(), "leaf.db")},
+		AutoCreateTable: true,
+	}
+	r, err := NewWorkerRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := entity.Worker{WorkerId: 1, Endpoint: "127.0.0.1:8080", LeaseExpire: 100, LastTimestamp: 50, Twepoch: 1}
+	if ok, err := r.InsertWorker(w); !ok || err != nil {
+		t.Fatalf("insert failed, ok:%v err:%v", ok, err)
+	}
+	if ok, err := r.InsertWorker(w); ok || err != nil {
+		t.Fatalf("expect duplicate, ok:%v err:%v", ok, err)
+	}
+
+	w.Endpoint = "127.0.0.1:8081"
+	if ok, err := r.UpdateWorker(w, 0); !ok || err != nil {
+		t.Fatalf("update failed, ok:%v err:%v", ok, err)
+	}
+	if ok, err := r.UpdateWorker(w, 0); ok || err != nil { // 版本号已变化
+		t.Fatalf("expect version conflict, ok:%v err:%v", ok, err)
+	}
+	workers, err := r.GetAllWorkers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Version = 1
+	if len(workers) != 1 || workers[0] != w {
+		t.Fatalf("unexpected workers:%+v", workers)
+	}
+}
@@ -0,0 +1,85 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/log"
+	"github.com/longyufei109/leaf-go/pb"
+	"github.com/longyufei109/leaf-go/service"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+)
+
+type server struct {
+	pb.UnimplementedLeafServer
+	svc service.IdGenerator
+}
+
+func NewServer(g service.IdGenerator) pb.LeafServer {
+	return &server{svc: g}
+}
+
+func Start(g service.IdGenerator) {
+	lis, err := net.Listen("tcp", config.Global.Grpc.Addr)
+	if err != nil {
+		log.Print("gRPC Server listen failed. addr:%s, err:%v", config.Global.Grpc.Addr, err)
+		return
+	}
+	s := gogrpc.NewServer()
+	pb.RegisterLeafServer(s, NewServer(g))
+	log.Print("gRPC Server start at [%s]", config.Global.Grpc.Addr)
+	log.Print("%v", s.Serve(lis))
+}
+
+func (s *server) Gen(_ context.Context, req *pb.GenRequest) (*pb.GenResponse, error) {
+	id, err := s.svc.Gen(req.Key)
+	if err != nil {
+		log.Print("gen failed, key:%s, err:%v", req.Key, err)
+		return nil, toStatus(err)
+	}
+	return &pb.GenResponse{Id: id}, nil
+}
+
+func (s *server) GenBatch(_ context.Context, req *pb.GenBatchRequest) (*pb.GenBatchResponse, error) {
+	if req.Count <= 0 || req.Count > service.MaxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid count:%d", req.Count)
+	}
+	ids, err := s.svc.GenBatch(req.Key, int(req.Count))
+	if err != nil {
+		log.Print("gen batch failed, key:%s, err:%v", req.Key, err)
+		return nil, toStatus(err)
+	}
+	return &pb.GenBatchResponse{Ids: ids}, nil
+}
+
+// 调用方的错误不使用 codes.Internal，避免计入服务端故障
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidArgument):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, service.ErrUnknownKey):
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func (s *server) Decode(_ context.Context, req *pb.DecodeRequest) (*pb.DecodeResponse, error) {
+	decoder, ok := s.svc.(service.Decoder)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "decode not supported in current mode")
+	}
+	info, err := decoder.Decode(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &pb.DecodeResponse{
+		Id:           info.Id,
+		Timestamp:    info.Timestamp,
+		DatacenterId: info.DatacenterId,
+		WorkerId:     info.WorkerId,
+		Sequence:     info.Sequence,
+	}, nil
+}
@@ -0,0 +1,87 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/longyufei109/leaf-go/client"
+	"github.com/longyufei109/leaf-go/pb"
+	"github.com/longyufei109/leaf-go/service"
+	"github.com/longyufei109/leaf-go/service/snowflake"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math"
+	"net"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	g := snowflake.New(snowflake.Config{WorkerIdGetter: func() int64 { return 1 }})
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := gogrpc.NewServer()
+	pb.RegisterLeafServer(s, NewServer(g))
+	go func() { _ = s.Serve(lis) }()
+	defer s.Stop()
+
+	c, err := client.NewGrpcClient(client.Config{Endpoints: []string{lis.Addr().String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	id, err := c.GetId("")
+	if err != nil || id <= 0 {
+		t.Fatalf("GetId failed, id:%d, err:%v", id, err)
+	}
+	ids, err := c.GetIds("", 100)
+	if err != nil || len(ids) != 100 {
+		t.Fatalf("GetIds failed, ids:%d, err:%v", len(ids), err)
+	}
+	if ids[0] <= id {
+		t.Fatalf("ids not increasing, %d <= %d", ids[0], id)
+	}
+	for _, n := range []int{0, math.MaxInt32 + 1, 1<<32 + 5} { // 超过int32时不能截断后发送(1<<32+5 截断后为5)
+		if _, err = c.GetIds("", n); err == nil {
+			t.Fatalf("expect error for count %d", n)
+		}
+	}
+	info, err := c.Decode(id)
+	if err != nil || info.WorkerId != 1 {
+		t.Fatalf("Decode failed, info:%+v, err:%v", info, err)
+	}
+}
+
+type errGen struct {
+	err error
+}
+
+func (g *errGen) Init() error                           { return nil }
+func (g *errGen) Gen(string) (int64, error)             { return -1, g.err }
+func (g *errGen) GenBatch(string, int) ([]int64, error) { return nil, g.err }
+func (g *errGen) Shutdown()                             {}
+
+func TestServer_ErrorCodes(t *testing.T) {
+	cases := []struct {
+		err  error
+		code codes.Code
+	}{
+		{fmt.Errorf("%w:test", service.ErrUnknownKey), codes.NotFound},
+		{fmt.Errorf("%w, invalid count:0", service.ErrInvalidArgument), codes.InvalidArgument},
+		{errors.New("all segments not ready"), codes.Internal},
+	}
+	for _, c := range cases {
+		s := NewServer(&errGen{c.err})
+		if _, err := s.Gen(context.Background(), &pb.GenRequest{Key: "test"}); status.Code(err) != c.code {
+			t.Errorf("Gen, err:%v, expect code %v", err, c.code)
+		}
+		if _, err := s.GenBatch(context.Background(), &pb.GenBatchRequest{Key: "test", Count: 10}); status.Code(err) != c.code {
+			t.Errorf("GenBatch, err:%v, expect code %v", err, c.code)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/entity"
+	"github.com/longyufei109/leaf-go/log"
+	"github.com/longyufei109/leaf-go/repo"
+	"github.com/longyufei109/leaf-go/service"
+	"github.com/longyufei109/leaf-go/service/snowflake/zookeeper"
+	stdhttp "net/http"
+	"strconv"
+)
+
+var workerAdmin *zookeeper.Admin
+
+// 管理接口，需认证，请求头 Authorization: Bearer {adminToken}
+// zookeeper方式获取workerId时的节点管理:
+// GET  {adminPath}/workers 列出已注册的节点
+// POST {adminPath}/workers/reclaim?days=N&dry_run=true 回收超过N天未上报的节点
+// segment模式下biz_tag的管理，请求体为json:
+// GET  {adminPath}/tags 列出所有biz_tag
+// POST {adminPath}/tags/create {"key":"order","step":1000,"max_id":1,"description":""}
+// POST {adminPath}/tags/update {"key":"order","step":2000,"description":""} step、description可以只传一个
+// POST {adminPath}/tags/delete {"key":"order"}
+func registerAdmin(mux *stdhttp.ServeMux) {
+	c := config.Global
+	if c.Http.AdminPath == "" {
+		return
+	}
+	if c.Http.AdminToken == "" {
+		log.Print("http.adminToken is not configured, admin api disabled")
+		return
+	}
+	if c.Mode == config.Mode_Snowflake && c.Snowflake.WorkerId < 0 &&
+		c.Snowflake.WorkerIdProvider == config.WorkerIdProvider_Zookeeper {
+		workerAdmin = zookeeper.NewAdmin(&c.Zookeeper, c.Snowflake.WorkerIdBits)
+		mux.HandleFunc(c.Http.AdminPath+"/workers", authenticate(listWorkers))
+		mux.HandleFunc(c.Http.AdminPath+"/workers/reclaim", authenticate(reclaimWorkers))
+	}
+	if _, ok := svc.(service.TagManager); ok {
+		mux.HandleFunc(c.Http.AdminPath+"/tags", authenticate(listTags))
+		mux.HandleFunc(c.Http.AdminPath+"/tags/create", authenticate(createTag))
+		mux.HandleFunc(c.Http.AdminPath+"/tags/update", authenticate(updateTag))
+		mux.HandleFunc(c.Http.AdminPath+"/tags/delete", authenticate(deleteTag))
+	}
+}
+
+func authenticate(h stdhttp.HandlerFunc) stdhttp.HandlerFunc {
+	return func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		expected := "Bearer " + config.Global.Http.AdminToken
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+			w.WriteHeader(stdhttp.StatusUnauthorized)
+			data, _ := json.Marshal(&response{Msg: "unauthorized"})
+			_, _ = w.Write(data)
+			return
+		}
+		h(w, r)
+	}
+}
+
+type workersResponse struct {
+	Workers []zookeeper.Worker `json:"workers"`
+	Msg     string             `json:"msg"`
+}
+
+func listWorkers(w stdhttp.ResponseWriter, _ *stdhttp.Request) {
+	resp := &workersResponse{}
+	var err error
+	if resp.Workers, err = workerAdmin.Workers(); err != nil {
+		resp.Msg = err.Error()
+		w.WriteHeader(stdhttp.StatusInternalServerError)
+	} else {
+		w.WriteHeader(stdhttp.StatusOK)
+	}
+	data, _ := json.Marshal(resp)
+	_, _ = w.Write(data)
+}
+
+func reclaimWorkers(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+	resp := &workersResponse{}
+	status := stdhttp.StatusOK
+	err := func() error {
+		if r.Method != stdhttp.MethodPost {
+			status = stdhttp.StatusMethodNotAllowed
+			return fmt.Errorf("method %s not allowed", r.Method)
+		}
+		query := r.URL.Query()
+		days, err := strconv.Atoi(query.Get("days"))
+		if err != nil || days < 1 {
+			status = stdhttp.StatusBadRequest
+			return fmt.Errorf("invalid days:%s", query.Get("days"))
+		}
+		dryRun := query.Get("dry_run") == "true"
+		if resp.Workers, err = workerAdmin.Reclaim(days, dryRun); err != nil {
+			status = stdhttp.StatusInternalServerError
+			return err
+		}
+		log.Print("reclaim workers dead for %d days, dryRun:%v, reclaimed:%d", days, dryRun, len(resp.Workers))
+		return nil
+	}()
+	if err != nil {
+		resp.Msg = err.Error()
+	}
+	w.WriteHeader(status)
+	data, _ := json.Marshal(resp)
+	_, _ = w.Write(data)
+}
+
+type tagInfo struct {
+	Key         string `json:"key"`
+	MaxId       int64  `json:"max_id"`
+	Step        int64  `json:"step"`
+	Description string `json:"description"`
+}
+
+type tagsResponse struct {
+	Tags []tagInfo `json:"tags,omitempty"`
+	Msg  string    `json:"msg"`
+}
+
+// tags/update 中未传的字段不修改
+type updateTagRequest struct {
+	Key         string  `json:"key"`
+	Step        *int64  `json:"step"`
+	Description *string `json:"description"`
+}
+
+func listTags(w stdhttp.ResponseWriter, _ *stdhttp.Request) {
+	tags, err := svc.(service.TagManager).Tags()
+	resp := &tagsResponse{Tags: []tagInfo{}}
+	for _, tag := range tags {
+		resp.Tags = append(resp.Tags, tagInfo{tag.Key, tag.MaxId, tag.Step, tag.Description})
+	}
+	writeTagResponse(w, resp, err)
+}
+
+func createTag(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+	req := &tagInfo{}
+	if !decodeTagRequest(w, r, req) {
+		return
+	}
+	err := svc.(service.TagManager).CreateTag(entity.Tag{Key: req.Key, MaxId: req.MaxId, Step: req.Step,
+		Description: req.Description})
+	writeTagResponse(w, &tagsResponse{}, err)
+}
+
+func updateTag(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+	req := &updateTagRequest{}
+	if !decodeTagRequest(w, r, req) {
+		return
+	}
+	manager := svc.(service.TagManager)
+	err := func() error {
+		if req.Step == nil && req.Description == nil {
+			return fmt.Errorf("%w, neither step nor description is specified", service.ErrInvalidArgument)
+		}
+		if req.Step != nil {
+			if err := manager.UpdateTagStep(req.Key, *req.Step); err != nil {
+				return err
+			}
+		}
+		if req.Description != nil {
+			return manager.UpdateTagDescription(req.Key, *req.Description)
+		}
+		return nil
+	}()
+	writeTagResponse(w, &tagsResponse{}, err)
+}
+
+func deleteTag(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+	req := &tagInfo{}
+	if !decodeTagRequest(w, r, req) {
+		return
+	}
+	writeTagResponse(w, &tagsResponse{}, svc.(service.TagManager).DeleteTag(req.Key))
+}
+
+// 只接受POST，请求体为json，失败时写入响应并返回false
+func decodeTagRequest(w stdhttp.ResponseWriter, r *stdhttp.Request, req interface{}) bool {
+	var err error
+	status := stdhttp.StatusBadRequest
+	if r.Method != stdhttp.MethodPost {
+		status = stdhttp.StatusMethodNotAllowed
+		err = fmt.Errorf("method %s not allowed", r.Method)
+	} else if err = json.NewDecoder(r.Body).Decode(req); err != nil {
+		err = fmt.Errorf("invalid request body. err:%v", err)
+	}
+	if err == nil {
+		return true
+	}
+	w.WriteHeader(status)
+	data, _ := json.Marshal(&tagsResponse{Msg: err.Error()})
+	_, _ = w.Write(data)
+	return false
+}
+
+func writeTagResponse(w stdhttp.ResponseWriter, resp *tagsResponse, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(stdhttp.StatusOK)
+	case errors.Is(err, service.ErrInvalidArgument):
+		w.WriteHeader(stdhttp.StatusBadRequest)
+	case errors.Is(err, repo.ErrTagNotFound):
+		w.WriteHeader(stdhttp.StatusNotFound)
+	case errors.Is(err, repo.ErrTagExists):
+		w.WriteHeader(stdhttp.StatusConflict)
+	default:
+		w.WriteHeader(stdhttp.StatusInternalServerError)
+	}
+	if err != nil {
+		log.Print("tag admin failed, err:%v", err)
+		resp.Msg = err.Error()
+		resp.Tags = nil
+	}
+	data, _ := json.Marshal(resp)
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,181 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/repo"
+	"github.com/longyufei109/leaf-go/service/segment"
+	"github.com/longyufei109/leaf-go/service/snowflake/zookeeper"
+	"github.com/longyufei109/leaf-go/service/snowflake/zookeeper/zktest"
+	stdhttp "net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWorkersAdmin(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	s, err := zktest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	zconf := &config.Zookeeper{LeafName: "test", Address: s.Addr(), Port: "8001"}
+	p := zookeeper.NewWorkerIdProvider(zconf, 0)
+	if _, err = p.Acquire(1023); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	workerAdmin = zookeeper.NewAdmin(zconf, 0)
+
+	w := httptest.NewRecorder()
+	listWorkers(w, httptest.NewRequest("GET", "/admin/workers", nil))
+	resp := workersResponse{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != 200 || len(resp.Workers) != 1 || resp.Workers[0].Port != "8001" {
+		t.Fatalf("unexpected response:%d %s", w.Code, w.Body.String())
+	}
+
+	cases := []struct {
+		method string
+		url    string
+		code   int
+	}{
+		{"GET", "/admin/workers/reclaim?days=1", 405},
+		{"POST", "/admin/workers/reclaim?days=0", 400},
+		{"POST", "/admin/workers/reclaim?days=1&dry_run=true", 200},
+		{"POST", "/admin/workers/reclaim?days=1", 200},
+	}
+	for _, c := range cases {
+		w = httptest.NewRecorder()
+		reclaimWorkers(w, httptest.NewRequest(c.method, c.url, nil))
+		resp = workersResponse{}
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != c.code || len(resp.Workers) != 0 {
+			t.Fatalf("%s %s, unexpected response:%d %s", c.method, c.url, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestTagsAdmin(t *testing.T) {
+	config.Global.DB = config.DBConfig{
+		Type:            config.DB_Type_SQLite,
+		DataSource:      []string{"file:" + filepath.Join(t.TempDir(), "leaf.db")},
+		AutoCreateTable: true,
+	}
+	r, err := repo.NewRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc = segment.New(r, segment.Config{})
+	if err = svc.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Shutdown()
+	origin := config.Global.Http
+	defer func() { config.Global.Http = origin }()
+	config.Global.Http.AdminPath, config.Global.Http.AdminToken = "/admin", "secret"
+	mux := stdhttp.NewServeMux()
+	registerAdmin(mux)
+
+	do := func(method, url, body, token string) (int, tagsResponse) {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		resp := tagsResponse{}
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	if code, _ := do("GET", "/admin/tags", "", ""); code != 401 {
+		t.Fatalf("expect 401 without token, got %d", code)
+	}
+	if code, _ := do("GET", "/admin/tags", "", "wrong"); code != 401 {
+		t.Fatalf("expect 401 with wrong token, got %d", code)
+	}
+
+	cases := []struct {
+		url  string
+		body string
+		code int
+	}{
+		{"/admin/tags/create", `{"key":"order","step":100,"description":"order id"}`, 200},
+		{"/admin/tags/create", `{"key":"order","step":100}`, 409},
+		{"/admin/tags/create", `{"key":"user","step":0}`, 400},
+		{"/admin/tags/create", `{"key":`, 400},
+		{"/admin/tags/update", `{"key":"order","step":200}`, 200},
+		{"/admin/tags/update", `{"key":"order","description":"new"}`, 200},
+		{"/admin/tags/update", `{"key":"order"}`, 400},
+		{"/admin/tags/update", `{"key":"user","step":200}`, 404},
+	}
+	for _, c := range cases {
+		if code, resp := do("POST", c.url, c.body, "secret"); code != c.code {
+			t.Fatalf("%s %s, expect %d, got %d %s", c.url, c.body, c.code, code, resp.Msg)
+		}
+	}
+	// 创建后立即可用
+	if _, err = svc.Gen("order"); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := do("GET", "/admin/tags/create", "", "secret"); code != 405 {
+		t.Fatalf("expect 405, got %d", code)
+	}
+	code, resp := do("GET", "/admin/tags", "", "secret")
+	if code != 200 || len(resp.Tags) != 1 || resp.Tags[0].Step != 200 || resp.Tags[0].Description != "new" {
+		t.Fatalf("unexpected response:%d %+v", code, resp)
+	}
+
+	if code, _ = do("POST", "/admin/tags/delete", `{"key":"order"}`, "secret"); code != 200 {
+		t.Fatalf("expect 200, got %d", code)
+	}
+	if code, _ = do("POST", "/admin/tags/delete", `{"key":"order"}`, "secret"); code != 404 {
+		t.Fatalf("expect 404, got %d", code)
+	}
+	if _, err = svc.Gen("order"); err == nil {
+		t.Fatal("expect error after tag deleted")
+	}
+}
+
+func TestWorkersAdmin_Authenticate(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	s, err := zktest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	origin := config.Global
+	defer func() { config.Global = origin }()
+	config.Global.Mode = config.Mode_Snowflake
+	config.Global.Snowflake.WorkerId = -1
+	config.Global.Snowflake.WorkerIdProvider = config.WorkerIdProvider_Zookeeper
+	config.Global.Zookeeper = config.Zookeeper{LeafName: "test", Address: s.Addr(), Port: "8001"}
+	config.Global.Http.AdminPath = "/admin"
+
+	do := func(mux *stdhttp.ServeMux, token string) int {
+		req := httptest.NewRequest("POST", "/admin/workers/reclaim?days=1&dry_run=true", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+	// 未配置token时不启用
+	mux := stdhttp.NewServeMux()
+	registerAdmin(mux)
+	if code := do(mux, ""); code != 404 {
+		t.Fatalf("expect 404 without admin token configured, got %d", code)
+	}
+
+	config.Global.Http.AdminToken = "secret"
+	mux = stdhttp.NewServeMux()
+	registerAdmin(mux)
+	for token, code := range map[string]int{"": 401, "wrong": 401, "secret": 200} {
+		if got := do(mux, token); got != code {
+			t.Fatalf("token:%q, expect %d, got %d", token, code, got)
+		}
+	}
+}
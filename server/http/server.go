@@ -2,12 +2,19 @@ package http
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/log"
+	"github.com/longyufei109/leaf-go/metrics"
 	"github.com/longyufei109/leaf-go/service"
-	"log"
 	stdhttp "net/http"
+	"strconv"
+	"time"
 )
 
+const countQuery = "count" // 批量获取id时的数量参数 => http://ip:port/api/id?key=xxx&count=N
+
 var svc service.IdGenerator
 
 func Start(g service.IdGenerator) {
@@ -15,33 +22,176 @@ func Start(g service.IdGenerator) {
 
 	mux := stdhttp.NewServeMux()
 	mux.HandleFunc(config.Global.Http.RequestPath, genId)
+	mux.HandleFunc(config.Global.Http.DecodePath, decodeId)
+	mux.HandleFunc(config.Global.Http.HealthPath, health)
+	mux.HandleFunc(config.Global.Http.ReadyPath, ready)
+	mux.HandleFunc(config.Global.Http.StatusPath, status)
+	mux.Handle(config.Global.Http.MetricsPath, metrics.Handler())
+	registerAdmin(mux)
 
 	server := stdhttp.Server{
 		Addr:    config.Global.Http.Addr,
 		Handler: mux,
 	}
 	log.Print("HTTP Server start at [%s]", config.Global.Http.Addr)
-	log.Print("", server.ListenAndServe())
+	log.Print("%v", server.ListenAndServe())
 }
 
 type response struct {
-	Id  int64  `json:"id"`
-	Msg string `json:"msg"`
+	Id  int64   `json:"id"`
+	Ids []int64 `json:"ids,omitempty"`
+	Msg string  `json:"msg"`
 }
 
 func genId(w stdhttp.ResponseWriter, r *stdhttp.Request) {
-	key := r.URL.Query().Get(config.Global.Http.Query)
-	id, err := svc.Gen(key)
-	resp := &response{
-		Id: id,
+	query := r.URL.Query()
+	key := query.Get(config.Global.Http.Query)
+	resp := &response{}
+	var err error
+	if countStr := query.Get(countQuery); countStr != "" {
+		var count int
+		if count, err = strconv.Atoi(countStr); err != nil {
+			err = fmt.Errorf("%w, invalid count:%s", service.ErrInvalidArgument, countStr)
+		} else {
+			resp.Ids, err = svc.GenBatch(key, count)
+		}
+		resp.Id = -1
+		if len(resp.Ids) > 0 {
+			resp.Id = resp.Ids[0]
+		}
+	} else {
+		resp.Id, err = svc.Gen(key)
 	}
 	if err != nil {
 		log.Print("genId failed, err:%v", err)
 		resp.Msg = err.Error()
-		w.WriteHeader(stdhttp.StatusInternalServerError)
+		w.WriteHeader(genIdStatusCode(err))
+	} else {
+		w.WriteHeader(stdhttp.StatusOK)
+	}
+	data, _ := json.Marshal(resp)
+	_, _ = w.Write(data)
+}
+
+// 调用方的错误不返回500，避免计入服务端故障
+func genIdStatusCode(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidArgument):
+		return stdhttp.StatusBadRequest
+	case errors.Is(err, service.ErrUnknownKey):
+		return stdhttp.StatusNotFound
+	}
+	return stdhttp.StatusInternalServerError
+}
+
+type decodeResponse struct {
+	Id           int64  `json:"id"`
+	Timestamp    int64  `json:"timestamp"`
+	Time         string `json:"time"`
+	DatacenterId int64  `json:"datacenter_id"`
+	WorkerId     int64  `json:"worker_id"`
+	Sequence     int64  `json:"sequence"`
+	Msg          string `json:"msg"`
+}
+
+// http://ip:port/api/decode?id=xxx
+func decodeId(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+	resp := &decodeResponse{}
+	err := func() error {
+		decoder, ok := svc.(service.Decoder)
+		if !ok {
+			return fmt.Errorf("decode not supported in current mode")
+		}
+		idStr := r.URL.Query().Get("id")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid id:%s", idStr)
+		}
+		info, err := decoder.Decode(id)
+		if err != nil {
+			return err
+		}
+		resp.Id = info.Id
+		resp.Timestamp = info.Timestamp
+		resp.Time = info.Time.Format(time.RFC3339Nano)
+		resp.DatacenterId = info.DatacenterId
+		resp.WorkerId = info.WorkerId
+		resp.Sequence = info.Sequence
+		return nil
+	}()
+	if err != nil {
+		resp.Msg = err.Error()
+		w.WriteHeader(stdhttp.StatusBadRequest)
+	} else {
+		w.WriteHeader(stdhttp.StatusOK)
+	}
+	data, _ := json.Marshal(resp)
+	_, _ = w.Write(data)
+}
+
+type healthResponse struct {
+	Status  string                 `json:"status"` // ok 或 unhealthy
+	Error   string                 `json:"error,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// http://ip:port/healthz 不健康时返回503，IdGenerator 未实现 service.HealthChecker 时总是健康
+func health(w stdhttp.ResponseWriter, _ *stdhttp.Request) {
+	resp := &healthResponse{Status: "ok"}
+	if checker, ok := svc.(service.HealthChecker); ok {
+		if err := checker.Healthy(); err != nil {
+			resp.Status = "unhealthy"
+			resp.Error = err.Error()
+		}
+		resp.Details = checker.HealthDetails()
+	}
+	if resp.Error != "" {
+		w.WriteHeader(stdhttp.StatusServiceUnavailable)
 	} else {
 		w.WriteHeader(stdhttp.StatusOK)
 	}
 	data, _ := json.Marshal(resp)
 	_, _ = w.Write(data)
 }
+
+type readyResponse struct {
+	Status string `json:"status"` // ready 或 not_ready
+	Error  string `json:"error,omitempty"`
+}
+
+// http://ip:port/readyz 无法分配id时返回503，IdGenerator 未实现 service.ReadinessChecker 时总是就绪
+func ready(w stdhttp.ResponseWriter, _ *stdhttp.Request) {
+	resp := &readyResponse{Status: "ready"}
+	if checker, ok := svc.(service.ReadinessChecker); ok {
+		if err := checker.Ready(); err != nil {
+			resp.Status = "not_ready"
+			resp.Error = err.Error()
+		}
+	}
+	if resp.Error != "" {
+		w.WriteHeader(stdhttp.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(stdhttp.StatusOK)
+	}
+	data, _ := json.Marshal(resp)
+	_, _ = w.Write(data)
+}
+
+// http://ip:port/status segment模式下各key的segmentBuf状态，snowflake模式下workerId、twepoch等信息
+func status(w stdhttp.ResponseWriter, _ *stdhttp.Request) {
+	reporter, ok := svc.(service.StatusReporter)
+	if !ok {
+		w.WriteHeader(stdhttp.StatusNotFound)
+		data, _ := json.Marshal(&response{Msg: "status not supported in current mode"})
+		_, _ = w.Write(data)
+		return
+	}
+	data, err := json.Marshal(reporter.Status())
+	if err != nil {
+		w.WriteHeader(stdhttp.StatusInternalServerError)
+		data, _ = json.Marshal(&response{Msg: err.Error()})
+	} else {
+		w.WriteHeader(stdhttp.StatusOK)
+	}
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,105 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/metrics"
+	"github.com/longyufei109/leaf-go/service/snowflake"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func initTestSvc(t *testing.T) {
+	config.Global.Http.Query = "key"
+	svc = snowflake.New(snowflake.Config{WorkerIdGetter: func() int64 { return 7 }})
+	if err := svc.Init(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenId(t *testing.T) {
+	initTestSvc(t)
+
+	w := httptest.NewRecorder()
+	genId(w, httptest.NewRequest("GET", "/api/id?key=test&count=5", nil))
+	resp := response{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != 200 || len(resp.Ids) != 5 || resp.Id != resp.Ids[0] {
+		t.Fatalf("unexpected response:%d %s", w.Code, w.Body.String())
+	}
+
+	for _, count := range []string{"abc", "0", "-1", "10001"} {
+		w = httptest.NewRecorder()
+		genId(w, httptest.NewRequest("GET", "/api/id?key=test&count="+count, nil))
+		if w.Code != 400 {
+			t.Fatalf("count:%s, unexpected response:%d %s", count, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestDecodeId(t *testing.T) {
+	initTestSvc(t)
+
+	id, _ := svc.Gen("")
+	w := httptest.NewRecorder()
+	decodeId(w, httptest.NewRequest("GET", "/api/decode?id="+strconv.FormatInt(id, 10), nil))
+	resp := decodeResponse{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != 200 || resp.Id != id || resp.WorkerId != 7 || resp.Time == "" {
+		t.Fatalf("unexpected response:%d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHealth(t *testing.T) {
+	initTestSvc(t)
+
+	w := httptest.NewRecorder()
+	health(w, httptest.NewRequest("GET", "/healthz", nil))
+	resp := healthResponse{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != 200 || resp.Status != "ok" || resp.Details["worker_id"] != float64(7) {
+		t.Fatalf("unexpected response:%d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReadyAndStatus(t *testing.T) {
+	initTestSvc(t)
+
+	w := httptest.NewRecorder()
+	ready(w, httptest.NewRequest("GET", "/readyz", nil))
+	resp := readyResponse{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != 200 || resp.Status != "ready" {
+		t.Fatalf("unexpected response:%d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	status(w, httptest.NewRequest("GET", "/status", nil))
+	st := map[string]interface{}{}
+	_ = json.Unmarshal(w.Body.Bytes(), &st)
+	if w.Code != 200 || st["mode"] != "snowflake" || st["worker_id"] != float64(7) {
+		t.Fatalf("unexpected response:%d %s", w.Code, w.Body.String())
+	}
+
+	svc.Shutdown()
+	w = httptest.NewRecorder()
+	ready(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != 503 {
+		t.Fatalf("unexpected response:%d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	initTestSvc(t)
+	if _, err := svc.GenBatch("", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `leaf_ids_issued_total{key="",mode="snowflake"}`) {
+		t.Fatalf("unexpected response:%d %s", w.Code, w.Body.String())
+	}
+}
@@ -5,14 +5,19 @@ import (
 	"github.com/longyufei109/leaf-go/config"
 	"github.com/longyufei109/leaf-go/log"
 	"github.com/longyufei109/leaf-go/repo"
+	"github.com/longyufei109/leaf-go/server/grpc"
 	"github.com/longyufei109/leaf-go/server/http"
 	"github.com/longyufei109/leaf-go/service"
 	"github.com/longyufei109/leaf-go/service/segment"
 	"github.com/longyufei109/leaf-go/service/snowflake"
+	"github.com/longyufei109/leaf-go/service/snowflake/db"
+	"github.com/longyufei109/leaf-go/service/snowflake/etcd"
+	"github.com/longyufei109/leaf-go/service/snowflake/k8s"
 	"github.com/longyufei109/leaf-go/service/snowflake/zookeeper"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 var g service.IdGenerator
@@ -20,7 +25,19 @@ var g service.IdGenerator
 func Start() {
 	if config.Global.Mode == config.Mode_Snowflake {
 		if config.Global.Snowflake.WorkerId < 0 {
-			g = zookeeper.NewSnowflakeZookeeper(&config.Global.Zookeeper)
+			switch config.Global.Snowflake.WorkerIdProvider {
+			case config.WorkerIdProvider_Etcd:
+				g = etcd.NewSnowflakeEtcd(&config.Global.Etcd, snowflakeConfig())
+			case config.WorkerIdProvider_DB:
+				var err error
+				if g, err = db.NewSnowflakeDB(&config.Global.DBWorker, snowflakeConfig()); err != nil {
+					panic(fmt.Sprintf("init repo failed. err:%s", err.Error()))
+				}
+			case config.WorkerIdProvider_K8s:
+				g = k8s.NewSnowflakeK8s(&config.Global.K8s, snowflakeConfig())
+			default:
+				g = zookeeper.NewSnowflakeZookeeper(&config.Global.Zookeeper, snowflakeConfig())
+			}
 		} else {
 			g = newSnowflake()
 		}
@@ -35,8 +52,11 @@ func Start() {
 	}
 
 	go http.Start(g)
+	if config.Global.Grpc.Addr != "" {
+		go grpc.Start(g)
+	}
 
-	sig := make(chan os.Signal)
+	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL)
 	<-sig
 	g.Shutdown()
@@ -44,13 +64,31 @@ func Start() {
 }
 
 func newSnowflake() service.IdGenerator {
+	conf := snowflakeConfig()
+	conf.WorkerIdGetter = config.Global.Snowflake.WorkerIdGetter
+	return snowflake.New(conf)
+}
+
+func snowflakeConfig() snowflake.Config {
+	c := config.Global.Snowflake
 	conf := snowflake.Config{
-		Twepoch: 0,
-		WorkerIdGetter: func() int64 {
-			return 0
+		Twepoch: c.TwepochMillis,
+		Layout: snowflake.Layout{
+			DatacenterIdBits: c.DatacenterIdBits,
+			WorkerIdBits:     c.WorkerIdBits,
+			SequenceBits:     c.SequenceBits,
 		},
+		DatacenterId:   c.DatacenterId,
+		MaxStartupWait: time.Duration(c.MaxStartupWait) * time.Millisecond,
+		ClockPolicy:    snowflake.ClockPolicy(c.ClockPolicy),
+		MaxBackwardMs:  c.MaxBackwardMs,
+		SpareWorkerIds: c.SpareWorkerIds,
+		LockFree:       c.LockFree,
 	}
-	return snowflake.New(conf)
+	if c.TimestampFile != "" {
+		conf.TimestampStore = snowflake.NewFileTimestampStore(c.TimestampFile)
+	}
+	return conf
 }
 
 func newSegment() service.IdGenerator {
@@ -58,5 +96,27 @@ func newSegment() service.IdGenerator {
 	if err != nil {
 		panic(fmt.Sprintf("init repo failed. err:%s", err.Error()))
 	}
-	return segment.New(r)
+	c := config.Global.Segment
+	conf := segment.Config{
+		RefreshInterval:    time.Duration(c.RefreshInterval) * time.Second,
+		AutoCreatePatterns: c.AutoCreate.Patterns,
+		AutoCreateStep:     c.AutoCreate.Step,
+		AutoCreateMaxId:    c.AutoCreate.MaxId,
+		TagStepPolicies:    map[string]segment.StepPolicy{},
+		PreloadRatio:       c.PreloadRatio,
+		Segments:           c.Segments,
+	}
+	if conf.StepPolicy, err = segment.NewStepPolicy(c.StepPolicy); err != nil {
+		panic(fmt.Sprintf("init step policy failed. err:%s", err.Error()))
+	}
+	for _, p := range c.TagStepPolicies {
+		policy, err := segment.NewStepPolicy(p)
+		if err != nil {
+			panic(fmt.Sprintf("init step policy of tags:%v failed. err:%s", p.Tags, err.Error()))
+		}
+		for _, tag := range p.Tags {
+			conf.TagStepPolicies[tag] = policy
+		}
+	}
+	return segment.New(r, conf)
 }
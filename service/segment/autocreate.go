@@ -0,0 +1,71 @@
+package segment
+
+import (
+	"errors"
+	"fmt"
+	"github.com/longyufei109/leaf-go/entity"
+	"github.com/longyufei109/leaf-go/log"
+	"github.com/longyufei109/leaf-go/repo"
+	"regexp"
+)
+
+const DefaultAutoCreateStep = 1000
+
+func (s *segmentGen) initAutoCreate() error {
+	if s.conf.AutoCreateStep == 0 {
+		s.conf.AutoCreateStep = DefaultAutoCreateStep
+	}
+	if s.conf.AutoCreateMaxId == 0 {
+		s.conf.AutoCreateMaxId = 1
+	}
+	if len(s.conf.AutoCreatePatterns) == 0 {
+		return nil
+	}
+	if err := checkStep(s.conf.AutoCreateStep); err != nil {
+		return fmt.Errorf("invalid auto create step. err:%w", err)
+	}
+	if s.conf.AutoCreateMaxId < 0 {
+		return fmt.Errorf("invalid auto create max_id:%d", s.conf.AutoCreateMaxId)
+	}
+	s.autoCreate = s.autoCreate[:0]
+	for _, p := range s.conf.AutoCreatePatterns {
+		re, err := regexp.Compile("^(?:" + p + ")$") // 完整匹配
+		if err != nil {
+			return fmt.Errorf("invalid auto create pattern:%q. err:%v", p, err)
+		}
+		s.autoCreate = append(s.autoCreate, re)
+	}
+	return nil
+}
+
+func (s *segmentGen) autoCreatable(key string) bool {
+	if key == "" || len(key) > maxKeyLength {
+		return false
+	}
+	for _, re := range s.autoCreate {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// 与 updateCacheFromRepo 串行执行，避免刚创建的key被刷新时删除
+// 多个节点同时创建时只有一个成功，其它节点得到 repo.ErrTagExists 后直接使用
+func (s *segmentGen) createSegmentBuf(key string) (*segmentBuf, error) {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+	if sb, ok := s.cache.Load(key); ok { // 并发请求中已有一个创建成功
+		return sb.(*segmentBuf), nil
+	}
+	err := s.repo.CreateTag(entity.Tag{Key: key, MaxId: s.conf.AutoCreateMaxId, Step: s.conf.AutoCreateStep,
+		Description: "auto created"})
+	if err == nil {
+		log.Print("[segment] auto create tag:%s, max_id:%d, step:%d", key, s.conf.AutoCreateMaxId, s.conf.AutoCreateStep)
+	} else if !errors.Is(err, repo.ErrTagExists) {
+		return nil, err
+	}
+	sb := newSegmentBuf(key, s.repo, s.bufOptions(key))
+	s.cache.Store(key, sb)
+	return sb, nil
+}
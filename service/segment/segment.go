@@ -2,18 +2,22 @@ package segment
 
 import (
 	"github.com/longyufei109/leaf-go/util"
+	"time"
 )
 
 type segment struct {
-	max   int64
-	step  int64
-	value util.AtomicInt64
+	max       int64
+	step      int64
+	value     util.AtomicInt64
+	activated time.Time // 开始使用的时间，用于 QpsStepPolicy 计算消耗速度
 }
 
+// 预加载时activated为加载时间，切换为当前segment时更新
 func (s *segment) reset(max, step int64) {
 	s.max = max
 	s.step = step
 	s.value = util.AtomicInt64(max - step)
+	s.activated = time.Now()
 }
 
 // 剩余多少值
@@ -22,12 +26,12 @@ func (s *segment) idle() int64 {
 	return s.max - v
 }
 
-// 消耗掉一个值
-func (s *segment) incr() (newValue int64) {
-	newValue = s.value.Add(1) - 1
+// 消耗掉n个值，返回其中有效的部分[start, end)，start >= end 表示已用完
+func (s *segment) incrN(n int64) (start, end int64) {
+	end = s.value.Add(n)
+	start = end - n
+	if end > s.max {
+		end = s.max
+	}
 	return
 }
-
-func (s *segment) valid(v int64) bool {
-	return v < s.max
-}
@@ -3,12 +3,13 @@ package segment
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"github.com/longyufei109/leaf-go/config"
 	"github.com/longyufei109/leaf-go/entity"
 	"github.com/longyufei109/leaf-go/log"
+	"github.com/longyufei109/leaf-go/metrics"
 	"github.com/longyufei109/leaf-go/repo"
 	"github.com/longyufei109/leaf-go/util"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sync"
@@ -18,33 +19,63 @@ import (
 const (
 	MaxStep                = 1e6     // 最大步长不超过 100w
 	SegmentDurationSeconds = 60 * 15 // 900s, 15分钟
+
+	DefaultPreloadRatio = 0.1 // 当前segment消耗10%后预加载下一个
+	DefaultSegments     = 2   // 双缓冲
+	MaxSegments         = 16
 )
 
-// 双缓冲
+type bufOptions struct {
+	policy       StepPolicy // 为nil时使用 DefaultStepPolicy
+	segments     int        // segment的数量，为0时使用 DefaultSegments
+	preloadRatio float64    // 为0时使用 DefaultPreloadRatio
+}
+
+// 多个segment组成的环，默认为双缓冲
+// 当前segment消耗了preloadRatio后，依次加载后面的segment，直到除当前segment外都已加载
+// repo不可用时，已加载的segment可以继续分配
 type segmentBuf struct {
 	key                 string // 通常为业务名,biz_tag（见repo/db.go注释）
 	repo                repo.Repo
-	segments            []*segment      // len = 2
-	pos                 int             // 当前使用的segment索引
-	initok              bool            // 是否初始化成功过
-	step                int64           // 当前step，[minStep, MaxStep]，动态变化
-	minStep             int64           // 最小步长，等于初始时从repo中加载到的step值。后续不会变化
-	lastUpdateTimestamp int64           // 用来动态改变step，单位秒
-	mu                  sync.RWMutex    // 读多写少场景
-	isNextReady         util.AtomicBool // 下一个segment是否准备好了
-	isLoadingNext       util.AtomicBool // 是否正在加载下一个segment，避免并发加载
+	policy              StepPolicy
+	preloadRatio        float64
+	segments            []*segment       // 环形
+	pos                 int              // 当前使用的segment索引
+	ready               util.AtomicInt64 // 当前segment之后已加载的segment数量，[0, len(segments)-1]
+	adjustStep          bool             // 切换segment后加载的第一个segment按StepPolicy调整step，stepMu保护
+	initok              bool             // 是否初始化成功过
+	step                int64            // 当前step，[minStep, MaxStep]，动态变化
+	minStep             int64            // 最小步长，等于repo中的step值，repo中修改后重置
+	lastUpdateTimestamp int64            // 用来动态改变step，单位秒
+	mu                  sync.RWMutex     // 读多写少场景
+	stepMu              sync.Mutex       // 保护step、minStep、lastUpdateTimestamp，见 updateSegment
+	isLoadingNext       util.AtomicBool  // 是否正在加载下一个segment，避免并发加载
 	stopped             util.AtomicBool
 }
 
-func newSegmentBuf(key string, r repo.Repo) *segmentBuf {
+func newSegmentBuf(key string, r repo.Repo, opts bufOptions) *segmentBuf {
+	if opts.policy == nil {
+		opts.policy = DefaultStepPolicy
+	}
+	if opts.segments == 0 {
+		opts.segments = DefaultSegments
+	}
+	if opts.preloadRatio == 0 {
+		opts.preloadRatio = DefaultPreloadRatio
+	}
 	sb := &segmentBuf{
-		key:      key,
-		repo:     r,
-		segments: []*segment{{}, {}},
+		key:          key,
+		repo:         r,
+		policy:       opts.policy,
+		preloadRatio: opts.preloadRatio,
+		segments:     make([]*segment, opts.segments),
+	}
+	for i := range sb.segments {
+		sb.segments[i] = &segment{}
 	}
 	if err := sb.load(); err != nil {
 		log.Print("load segment buf from file failed. buf:%s. err:%s. try load from repo", sb.key, err.Error())
-		if err := sb.updateSegment(sb.curSegment()); err == nil {
+		if err := sb.updateSegment(sb.curSegment(), nil); err == nil {
 			sb.initSuccess()
 			log.Print("load segment buf from repo success. buf:%s", sb.key)
 		} else {
@@ -58,15 +89,23 @@ func newSegmentBuf(key string, r repo.Repo) *segmentBuf {
 
 func (sb *segmentBuf) initSuccess() {
 	sb.initok = true
+	sb.stepMu.Lock()
+	sb.adjustStep = true
+	sb.stepMu.Unlock()
 }
 
 func (sb *segmentBuf) nextPos() int {
-	return (sb.pos + 1) % 2
+	return (sb.pos + 1) % len(sb.segments)
 }
 
-// 写锁保护时调用
+// 写锁保护时调用，需已有加载好的segment
 func (sb *segmentBuf) switchPos() {
 	sb.pos = sb.nextPos()
+	sb.ready.Add(-1)
+	sb.curSegment().activated = time.Now()
+	sb.stepMu.Lock()
+	sb.adjustStep = true
+	sb.stepMu.Unlock()
 }
 
 // 至少得读锁保护时使用
@@ -74,47 +113,61 @@ func (sb *segmentBuf) curSegment() *segment {
 	return sb.segments[sb.pos]
 }
 
-func (sb *segmentBuf) nextSegment() *segment {
-	return sb.segments[sb.nextPos()]
+// 当前segment之后第i个，i从1开始
+func (sb *segmentBuf) aheadSegment(i int) *segment {
+	return sb.segments[(sb.pos+i)%len(sb.segments)]
+}
+
+// 下一个要加载的segment，至少得读锁保护时使用
+func (sb *segmentBuf) loadingSegment() *segment {
+	return sb.aheadSegment(int(sb.ready.Value()) + 1)
+}
+
+// 除当前segment外都已加载
+func (sb *segmentBuf) full() bool {
+	return sb.ready.Value() >= int64(len(sb.segments)-1)
 }
 
 func (sb *segmentBuf) nextId() (int64, error) {
+	if err := sb.ensureInit(); err != nil {
+		return -1, err
+	}
+	return sb.getIdFromSegment()
+}
+
+func (sb *segmentBuf) ensureInit() error {
 	if !sb.initok { // todo remove this, always init segmentBuf in newSegmentBuf()
 		sb.mu.Lock()
+		defer sb.mu.Unlock()
 		if !sb.initok {
-			err := sb.updateSegment(sb.curSegment())
-			if err != nil {
-				sb.mu.Unlock()
-				return -1, err
+			if err := sb.updateSegment(sb.curSegment(), nil); err != nil {
+				sb.countError(metrics.ErrorRepo)
+				return err
 			}
 			sb.initSuccess()
 		}
-		sb.mu.Unlock()
 	}
-	return sb.getIdFromSegment()
+	return nil
 }
 
 // 除了在Init中调用(实际上Init中也可以不调用，Init中加载是为了减少nextId时的锁竞争)
-// 其它地方都需要写锁保护
-func (sb *segmentBuf) updateSegment(s *segment) (err error) {
+// 其它地方都需要写锁保护，loadNextSegment中加载下一个segment时除外
+// step、minStep、lastUpdateTimestamp 还会被刷新缓存时修改，由stepMu保护
+// cur为调用方持有锁时获取的当前segment，用于计算StepContext，未初始化时为nil
+func (sb *segmentBuf) updateSegment(s *segment, cur *segment) (err error) {
 	var seg entity.Segment
+	sb.stepMu.Lock()
+	defer sb.stepMu.Unlock()
 	newStep := sb.step
-	if !sb.initok || sb.lastUpdateTimestamp == 0 { // 如果还未初始化
+	if !sb.initok || sb.lastUpdateTimestamp == 0 || cur == nil { // 如果还未初始化
 		if seg, err = sb.repo.UpdateMaxIdAndGetSegment(sb.key); err != nil {
 			return err
 		}
 		newStep = seg.Step
-	} else { // 如果已初始化，动态调整step
-		// 计算新的step
-		newStep = sb.step
-		duration := curTimeInSecond() - sb.lastUpdateTimestamp // 距离上次更新的间隔
-		if duration < SegmentDurationSeconds {                 // 如果间隔过小(小于指定值)则2倍速增加步长，但不超过最大步长
-			if newStep*2 < MaxStep {
-				newStep *= 2
-			}
-		} else if duration > SegmentDurationSeconds*2 { // 如果间隔过大(大于指定值)则2倍速减少步长，但不小于最小步长
-			if newStep/2 > sb.minStep {
-				newStep /= 2
+	} else { // 如果已初始化，按StepPolicy动态调整step，连续加载多个segment时只在切换后的第一次调整
+		if sb.adjustStep {
+			if newStep = sb.policy.NextStep(sb.stepContext(cur)); newStep < 1 {
+				newStep = 1
 			}
 		}
 		// 更新repo中maxId
@@ -125,29 +178,104 @@ func (sb *segmentBuf) updateSegment(s *segment) (err error) {
 	sb.step = newStep
 	sb.minStep = seg.Step
 	sb.lastUpdateTimestamp = curTimeInSecond()
+	sb.adjustStep = false
+	metrics.SegmentStep.WithLabelValues(sb.key).Set(float64(newStep))
 
 	s.reset(seg.MaxId, newStep)
 	sb.dump()
 	return nil
 }
 
+// stepMu保护时调用
+func (sb *segmentBuf) stepContext(cur *segment) StepContext {
+	consumed := cur.step - cur.idle()
+	if consumed > cur.step {
+		consumed = cur.step
+	}
+	return StepContext{
+		Key:              sb.key,
+		Step:             sb.step,
+		MinStep:          sb.minStep,
+		SinceLastUpdate:  time.Duration(curTimeInSecond()-sb.lastUpdateTimestamp) * time.Second,
+		Consumed:         consumed,
+		ConsumedDuration: time.Since(cur.activated),
+	}
+}
+
+// repo中的step被修改后调用，之后的动态调整以新的step为基准
+func (sb *segmentBuf) resetStep(step int64) {
+	sb.stepMu.Lock()
+	defer sb.stepMu.Unlock()
+	sb.step = step
+	sb.minStep = step
+}
+
+// 与repo中的step不一致时重置，返回是否重置了
+func (sb *segmentBuf) syncStep(step int64) bool {
+	sb.mu.RLock() // initok
+	defer sb.mu.RUnlock()
+	sb.stepMu.Lock()
+	defer sb.stepMu.Unlock()
+	if !sb.initok || sb.minStep == step { // 未初始化时在初始化时加载
+		return false
+	}
+	sb.step = step
+	sb.minStep = step
+	metrics.SegmentStep.WithLabelValues(sb.key).Set(float64(step))
+	return true
+}
+
+func (sb *segmentBuf) nextIds(n int64) ([]int64, error) {
+	if err := sb.ensureInit(); err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, n)
+	for int64(len(ids)) < n { // 当前segment不够时，剩余部分从下一个segment中取
+		// 已取出部分id时，下一个segment未加载好则同步加载，避免失败后已取出的id被浪费
+		start, end, err := sb.getRangeFromSegment(n-int64(len(ids)), len(ids) > 0)
+		if err != nil {
+			return nil, err
+		}
+		for id := start; id < end; id++ {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
 func (sb *segmentBuf) getIdFromSegment() (int64, error) {
+	id, _, err := sb.getRangeFromSegment(1, false)
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+// 从segment中取出至多n个连续的id，返回[start, end)
+// 当前segment剩余不足n个时只返回剩余部分，由调用方继续获取
+// 当前segment已用完且没有加载好的segment时，syncLoad为true则等待进行中的加载或同步加载
+func (sb *segmentBuf) getRangeFromSegment(n int64, syncLoad bool) (start, end int64, err error) {
 	sb.mu.RLock()
 	if sb.stopped.True() {
 		sb.mu.RUnlock()
-		return -1, fmt.Errorf("server closed")
+		sb.countError(metrics.ErrorClosed)
+		return -1, -1, fmt.Errorf("server closed")
 	}
 	seg := sb.curSegment()
-	// 如果已经消耗了10% 且 下一个segment尚未加载，则预加载下一个segment
-	if float64(seg.idle()) < 0.9*float64(seg.step) && !sb.isNextReady.True() {
+	// 如果已经消耗了preloadRatio(默认10%) 且 还有segment尚未加载，则预加载下一个segment
+	if float64(seg.idle()) < (1-sb.preloadRatio)*float64(seg.step) && !sb.full() {
 		// 如果已经在加载了则不进行加载，避免并发加载，造成浪费
 		if sb.isLoadingNext.False2True() {
-			go sb.loadNextSegment()
+			if sb.full() { // 上一次加载刚刚完成
+				sb.isLoadingNext.Set(false)
+			} else {
+				go sb.loadNextSegment(sb.loadingSegment(), seg)
+			}
 		}
 	}
-	if id := seg.incr(); seg.valid(id) {
+	if start, end = seg.incrN(n); start < end {
 		sb.mu.RUnlock()
-		return id, nil
+		return
 	}
 	sb.mu.RUnlock()
 	// 当前segment已用完
@@ -163,37 +291,65 @@ func (sb *segmentBuf) getIdFromSegment() (int64, error) {
 	sb.mu.Lock() // 有可能多个go routine阻塞在这里
 	defer sb.mu.Unlock()
 	if sb.stopped.True() {
-		return -1, fmt.Errorf("server closed")
+		sb.countError(metrics.ErrorClosed)
+		return -1, -1, fmt.Errorf("server closed")
 	}
-	seg = sb.curSegment() // 这里是为了后面(第2、3...个)进来的协程获取id，因为第1个协程将isNextReady置为false
-	if id := seg.incr(); seg.valid(id) {
-		return id, nil
+	seg = sb.curSegment() // 这里是为了后面(第2、3...个)进来的协程获取id，因为第1个协程已经切换了segment
+	if start, end = seg.incrN(n); start < end {
+		return
 	}
 
-	if sb.isNextReady.True() { // 第一个拿到锁的协程进入if，并负责切换segment
+	if syncLoad && sb.ready.Value() == 0 {
+		sb.loadSync()
+	}
+	if sb.ready.Value() > 0 { // 第一个拿到锁的协程进入if，并负责切换segment
 		sb.switchPos()
+		metrics.SegmentSwitches.WithLabelValues(sb.key).Inc()
 		sb.dump()
-		sb.isNextReady.Set(false)
 
 		seg = sb.curSegment()
-		if id := seg.incr(); seg.valid(id) {
-			return id, nil
+		if start, end = seg.incrN(n); start < end {
+			return
 		} else {
-			return -1, fmt.Errorf("new segment exhausted, buf:%s", sb.key)
+			sb.countError(metrics.ErrorSegmentExhausted)
+			return -1, -1, fmt.Errorf("new segment exhausted, buf:%s", sb.key)
 		}
 	}
-	return -1, fmt.Errorf("both two segments not ready, buf:%s", sb.key)
+	sb.countError(metrics.ErrorSegmentsNotReady)
+	metrics.SegmentNotReady.WithLabelValues(sb.key).Inc()
+	return -1, -1, fmt.Errorf("all segments not ready, buf:%s", sb.key)
+}
+
+func (sb *segmentBuf) countError(typ string) {
+	metrics.IdErrors.WithLabelValues(metrics.ModeSegment, sb.key, typ).Inc()
+}
+
+// 每次加载一个，加载完成后ready加1，之后才能切换到该segment
+// 正在加载的segment在已加载的segment之后，分配和切换时不会访问
+// 调用方持有读锁并将isLoadingNext置为true后，通过 loadingSegment 获取s，cur为当时的当前segment
+// 加载时不能再获取锁，store、remove持有写锁等待加载完成
+func (sb *segmentBuf) loadNextSegment(s *segment, cur *segment) {
+	if err := sb.updateSegment(s, cur); err == nil {
+		sb.ready.Add(1)
+	} else {
+		log.Print("[loadNextSegment] updateSegment err:%v", err)
+	}
+	sb.isLoadingNext.Set(false)
 }
 
-func (sb *segmentBuf) loadNextSegment() {
-	if sb.isNextReady.True() {
+// 写锁保护时调用，加载不获取mu，持有写锁时可以等待
+func (sb *segmentBuf) loadSync() {
+	for sb.isLoadingNext.True() { // 等待进行中的加载
+		time.Sleep(time.Millisecond)
+	}
+	if sb.ready.Value() > 0 || !sb.isLoadingNext.False2True() {
 		return
 	}
-	sb.isLoadingNext.Set(true)
-	if err := sb.updateSegment(sb.nextSegment()); err == nil {
-		sb.isNextReady.Set(true)
+	if err := sb.updateSegment(sb.loadingSegment(), sb.curSegment()); err == nil {
+		sb.ready.Add(1)
 	} else {
-		log.Print("[loadNextSegment] updateSegment err:%v", err)
+		sb.countError(metrics.ErrorRepo)
+		log.Print("[loadSync] updateSegment err:%v", err)
 	}
 	sb.isLoadingNext.Set(false)
 }
@@ -206,20 +362,116 @@ func (sb *segmentBuf) dump() {
 	//log.Print("init:%v, lastTS:%d", sb.initok, sb.lastUpdateTimestamp)
 	//seg := sb.curSegment()
 	//log.Print("pos:%d, seg:{max:%d, step:%d, value:%d}", sb.pos, seg.max, seg.step, seg.value.Value())
-	//seg = sb.nextSegment()
+	//seg = sb.aheadSegment(1)
 	//log.Print("pos:%d, seg:{max:%d, step:%d, value:%d}", sb.nextPos(), seg.max, seg.step, seg.value.Value())
 }
 
+type segmentBufStatus struct {
+	InitOk              bool            `json:"init_ok"`
+	Pos                 int             `json:"pos"`
+	Step                int64           `json:"step"`
+	MinStep             int64           `json:"min_step"`
+	Idle                int64           `json:"idle"` // 当前segment剩余的id数量
+	IsNextReady         bool            `json:"is_next_ready"`
+	Ready               int64           `json:"ready"` // 当前segment之后已加载的segment数量
+	IsLoadingNext       bool            `json:"is_loading_next"`
+	LastUpdateTimestamp int64           `json:"last_update_timestamp"`
+	Segments            []segmentStatus `json:"segments"`
+}
+
+type segmentStatus struct {
+	Max   int64 `json:"max"`
+	Step  int64 `json:"step"`
+	Value int64 `json:"value"`
+	Idle  int64 `json:"idle"`
+}
+
+func (sb *segmentBuf) status() segmentBufStatus {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	sb.stepMu.Lock()
+	defer sb.stepMu.Unlock()
+	st := segmentBufStatus{
+		InitOk:              sb.initok,
+		Pos:                 sb.pos,
+		Step:                sb.step,
+		MinStep:             sb.minStep,
+		Idle:                nonNegative(sb.curSegment().idle()),
+		IsNextReady:         sb.ready.Value() > 0,
+		Ready:               sb.ready.Value(),
+		IsLoadingNext:       sb.isLoadingNext.True(),
+		LastUpdateTimestamp: sb.lastUpdateTimestamp,
+	}
+	for _, seg := range sb.segments {
+		st.Segments = append(st.Segments, segmentStatus{seg.max, seg.step, seg.value.Value(), nonNegative(seg.idle())})
+	}
+	return st
+}
+
+// 已缓冲的id数量，及按当前segment的消耗速度可以使用的秒数，没有消耗时seconds为-1
+// 未初始化时ok为false
+func (sb *segmentBuf) runway() (ids int64, seconds float64, ok bool) {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	if !sb.initok {
+		return 0, 0, false
+	}
+	cur := sb.curSegment()
+	idle := nonNegative(cur.idle())
+	ids = idle
+	for i := 1; i <= int(sb.ready.Value()); i++ {
+		ids += nonNegative(sb.aheadSegment(i).idle())
+	}
+	consumed, elapsed := cur.step-idle, time.Since(cur.activated).Seconds()
+	if consumed <= 0 || elapsed <= 0 {
+		return ids, -1, true
+	}
+	return ids, float64(ids) * elapsed / float64(consumed), true
+}
+
+// 并发获取时value可能超过max
+func nonNegative(v int64) int64 {
+	if v < 0 {
+		return 0
+	}
+	return v
+}
+
 type segBufCache struct {
 	Key     string `json:"key"`
 	Step    int64  `json:"step"`
 	MinStep int64  `json:"min_step"`
 	Pos     int    `json:"pos"`
-	Segs    []struct {
-		Max   int64 `json:"max"`
-		Step  int64 `json:"step"`
-		Value int64 `json:"value"`
-	} `json:"segs"`
+	// 当前segment之后已加载的segment数量，旧版本的缓存文件没有该字段，只有两个segment
+	Ready *int           `json:"ready,omitempty"`
+	Segs  []segmentCache `json:"segs"`
+}
+
+type segmentCache struct {
+	Max   int64 `json:"max"`
+	Step  int64 `json:"step"`
+	Value int64 `json:"value"`
+}
+
+// repo中已删除时调用，与store一样先停止分配，等待进行中的分配完成后删除缓存文件
+// 避免重启或以同样的key重新创建时加载到旧的segment
+func (sb *segmentBuf) remove() {
+	sb.stopped.Set(true)
+
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	for sb.isLoadingNext.True() { // 等待完成加载
+		time.Sleep(100 * time.Millisecond)
+	}
+	fp := sb.cacheFile()
+	if err := os.Remove(fp); err != nil && !os.IsNotExist(err) {
+		log.Print("[segmentBuf] remove cache file failed. key:%s, path:%s, err:%v", sb.key, fp, err)
+	}
+}
+
+func (sb *segmentBuf) cacheFile() string {
+	return filepath.Join(config.Global.Segment.CacheDir, sb.key+".json")
 }
 
 func (sb *segmentBuf) store() {
@@ -235,20 +487,19 @@ func (sb *segmentBuf) store() {
 	for sb.isLoadingNext.True() { // 等待完成加载
 		time.Sleep(100 * time.Millisecond)
 	}
+	sb.stepMu.Lock()
+	defer sb.stepMu.Unlock()
 	// 存文件
+	ready := int(sb.ready.Value())
 	cache := &segBufCache{
 		Key:     sb.key,
 		Step:    sb.step,
 		MinStep: sb.minStep,
 		Pos:     sb.pos,
-		Segs: []struct {
-			Max   int64 `json:"max"`
-			Step  int64 `json:"step"`
-			Value int64 `json:"value"`
-		}{
-			{sb.curSegment().max, sb.curSegment().step, sb.curSegment().value.Value()},
-			{sb.nextSegment().max, sb.nextSegment().step, sb.nextSegment().value.Value()},
-		},
+		Ready:   &ready,
+	}
+	for _, seg := range sb.segments {
+		cache.Segs = append(cache.Segs, segmentCache{seg.max, seg.step, seg.value.Value()})
 	}
 	// 检查路径是否存在 不存在则创建
 	fi, err := os.Stat(config.Global.Segment.CacheDir)
@@ -263,8 +514,7 @@ func (sb *segmentBuf) store() {
 		}
 	}
 
-	filename := sb.key + ".json"
-	fp := filepath.Join(config.Global.Segment.CacheDir, filename)
+	fp := sb.cacheFile()
 	f, err := os.Create(fp)
 	if err != nil {
 		log.Print("[segmentBuf] store create fiale failed. key:%s", sb.key)
@@ -277,9 +527,7 @@ func (sb *segmentBuf) store() {
 }
 
 func (sb *segmentBuf) load() error {
-	filename := sb.key + ".json"
-	fp := filepath.Join(config.Global.Segment.CacheDir, filename)
-	f, err := os.Open(fp)
+	f, err := os.Open(sb.cacheFile())
 	if err != nil {
 		return err
 	}
@@ -294,19 +542,33 @@ func (sb *segmentBuf) load() error {
 	if err = json.Unmarshal(data, sbCache); err != nil {
 		return err
 	}
-	sb.pos = sbCache.Pos
+	n := len(sbCache.Segs)
+	if sbCache.Pos < 0 || sbCache.Pos >= n {
+		return fmt.Errorf("invalid cache, pos:%d, segs:%d", sbCache.Pos, n)
+	}
+	ready := 1 // 旧版本的缓存文件
+	if sbCache.Ready != nil {
+		ready = *sbCache.Ready
+	}
 	sb.step = sbCache.MinStep
 	sb.minStep = sbCache.MinStep
 	sb.lastUpdateTimestamp = curTimeInSecond()
+	metrics.SegmentStep.WithLabelValues(sb.key).Set(float64(sb.step))
 
-	sb.curSegment().max = sbCache.Segs[sb.pos].Max
-	sb.curSegment().step = sbCache.Segs[sb.pos].Step
-	sb.curSegment().value = util.AtomicInt64(sbCache.Segs[sb.pos].Value)
-
-	sb.nextSegment().max = sbCache.Segs[sb.nextPos()].Max
-	sb.nextSegment().step = sbCache.Segs[sb.nextPos()].Step
-	sb.nextSegment().value = util.AtomicInt64(sbCache.Segs[sb.nextPos()].Value)
-	sb.isNextReady.Set(sb.nextSegment().idle() > 0) // or sb.nextSegment().idle()==sb.nextSegment().step
+	// segment数量可能已修改，从0开始依次放入当前segment和之后已加载的segment，放不下的丢弃
+	sb.pos = 0
+	for i := 0; i <= ready && i < n && i < len(sb.segments); i++ {
+		c := sbCache.Segs[(sbCache.Pos+i)%n]
+		seg := sb.segments[i]
+		seg.max, seg.step, seg.value = c.Max, c.Step, util.AtomicInt64(c.Value)
+		if i > 0 {
+			if seg.idle() <= 0 {
+				break
+			}
+			sb.ready.Add(1)
+		}
+	}
+	sb.curSegment().activated = time.Now()
 	sb.initSuccess()
 	return nil
 }
@@ -0,0 +1,224 @@
+package segment
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/entity"
+	"github.com/longyufei109/leaf-go/repo"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memRepo struct {
+	mu     sync.Mutex
+	steps  map[string]int64
+	maxes  map[string]int64
+	err    error         // GetAllKeys 返回的错误，模拟repo不可用
+	segErr error         // 获取segment时返回的错误
+	delay  time.Duration // 获取segment的耗时，模拟慢的repo
+	notify chan struct{}
+}
+
+func newMemRepo(step int64, keys ...string) *memRepo {
+	r := &memRepo{steps: map[string]int64{}, maxes: map[string]int64{}, notify: make(chan struct{}, 1)}
+	for _, key := range keys {
+		r.steps[key] = step
+		r.maxes[key] = 1
+	}
+	return r
+}
+
+func (r *memRepo) GetAllKeys() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	var keys []string
+	for key := range r.steps {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (r *memRepo) UpdateMaxIdAndGetSegment(key string) (entity.Segment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.update(key, r.steps[key])
+}
+
+func (r *memRepo) UpdateMaxIdByStepAndGetSegment(key string, step int64) (entity.Segment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.update(key, step)
+}
+
+func (r *memRepo) update(key string, step int64) (entity.Segment, error) {
+	time.Sleep(r.delay)
+	if r.segErr != nil {
+		return entity.Segment{}, r.segErr
+	}
+	if _, ok := r.steps[key]; !ok {
+		return entity.Segment{}, fmt.Errorf("not found, key:%s", key)
+	}
+	r.maxes[key] += step
+	return entity.Segment{Key: key, Step: r.steps[key], MaxId: r.maxes[key]}, nil
+}
+
+func (r *memRepo) GetAllTags() ([]entity.Tag, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tags []entity.Tag
+	for key, step := range r.steps {
+		tags = append(tags, entity.Tag{Key: key, MaxId: r.maxes[key], Step: step})
+	}
+	return tags, nil
+}
+
+func (r *memRepo) CreateTag(tag entity.Tag) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.steps[tag.Key]; ok {
+		return repo.ErrTagExists
+	}
+	r.steps[tag.Key] = tag.Step
+	r.maxes[tag.Key] = tag.MaxId
+	r.changed()
+	return nil
+}
+
+func (r *memRepo) UpdateStep(key string, step int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.steps[key]; !ok {
+		return repo.ErrTagNotFound
+	}
+	r.steps[key] = step
+	r.changed()
+	return nil
+}
+
+func (r *memRepo) UpdateDescription(key string, _ string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.steps[key]; !ok {
+		return repo.ErrTagNotFound
+	}
+	return nil
+}
+
+func (r *memRepo) DeleteTag(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.steps[key]; !ok {
+		return repo.ErrTagNotFound
+	}
+	delete(r.steps, key)
+	delete(r.maxes, key)
+	r.changed()
+	return nil
+}
+
+// 模拟其它节点的通知
+func (r *memRepo) changed() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (r *memRepo) WatchTags(_ <-chan struct{}) <-chan struct{} {
+	return r.notify
+}
+
+func TestSegmentBuf_NextIds(t *testing.T) {
+	sb := newSegmentBuf("batch", newMemRepo(100, "batch"), bufOptions{})
+
+	seen := map[int64]bool{}
+	for i := 0; i < 50; i++ {
+		ids, err := sb.nextIds(37) // 不能整除step，需要跨segment分配
+		if err != nil {
+			t.Fatalf("nextIds failed, err:%v", err)
+		}
+		if len(ids) != 37 {
+			t.Fatalf("expect 37 ids, got %d", len(ids))
+		}
+		for _, id := range ids {
+			if seen[id] {
+				t.Fatalf("duplicate id:%d", id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+// 跨segment的批量获取，下一个segment加载较慢时等待加载完成
+func TestSegmentBuf_NextIdsSlowRepo(t *testing.T) {
+	r := newMemRepo(100, "slow")
+	sb := newSegmentBuf("slow", r, bufOptions{})
+	r.mu.Lock()
+	r.delay = 100 * time.Millisecond
+	r.mu.Unlock()
+
+	ids, err := sb.nextIds(150)
+	if err != nil {
+		t.Fatalf("nextIds failed, err:%v", err)
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Fatalf("ids not increasing, %d <= %d", ids[i], ids[i-1])
+		}
+	}
+	if len(ids) != 150 || ids[0] != 1 {
+		t.Fatalf("unexpected ids, len:%d, first:%d", len(ids), ids[0])
+	}
+}
+
+func TestSegmentBuf_StoreLoad(t *testing.T) {
+	originDir := config.Global.Segment.CacheDir
+	config.Global.Segment.CacheDir = t.TempDir()
+	defer func() { config.Global.Segment.CacheDir = originDir }()
+
+	r := newMemRepo(100, "cache")
+	sb := newSegmentBuf("cache", r, bufOptions{segments: 3})
+	if _, err := sb.nextIds(20); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		_, _ = sb.nextId()
+		return sb.full()
+	}, "segments not loaded")
+	before := sb.status()
+	sb.store()
+
+	// 重启后恢复已加载的segment，repo不可用时继续分配
+	r.mu.Lock()
+	r.segErr = fmt.Errorf("connection refused")
+	r.mu.Unlock()
+	loaded := newSegmentBuf("cache", r, bufOptions{segments: 3})
+	after := loaded.status()
+	if !after.InitOk || after.Ready != 2 || after.Idle != before.Idle || after.Segments[2].Max != before.Segments[(before.Pos+2)%3].Max {
+		t.Fatalf("unexpected status, before:%+v, after:%+v", before, after)
+	}
+	ids, _, _ := loaded.runway()
+	if _, err := loaded.nextIds(ids); err != nil {
+		t.Fatalf("expect %d buffered ids, err:%v", ids, err)
+	}
+	if _, err := loaded.nextId(); err == nil {
+		t.Fatal("expect error when all segments exhausted")
+	}
+
+	// 旧版本的缓存文件，没有ready字段
+	data, _ := json.Marshal(map[string]interface{}{"key": "cache", "step": 100, "min_step": 100, "pos": 1,
+		"segs": []segmentCache{{Max: 201, Step: 100, Value: 150}, {Max: 101, Step: 100, Value: 101}}})
+	if err := os.WriteFile(loaded.cacheFile(), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := newSegmentBuf("cache", r, bufOptions{})
+	if st := old.status(); !st.InitOk || st.Ready != 1 || st.Idle != 0 || st.Segments[1].Value != 150 {
+		t.Fatalf("unexpected status:%+v", st)
+	}
+}
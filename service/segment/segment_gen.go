@@ -2,20 +2,52 @@ package segment
 
 import (
 	"fmt"
+	"github.com/longyufei109/leaf-go/log"
+	"github.com/longyufei109/leaf-go/metrics"
 	"github.com/longyufei109/leaf-go/repo"
 	"github.com/longyufei109/leaf-go/service"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+const DefaultRefreshInterval = time.Minute
+
+type Config struct {
+	// 从repo刷新biz_tag的间隔，为0时使用默认值 DefaultRefreshInterval
+	// repo实现了 repo.TagNotifier 时，收到通知后也会立即刷新
+	RefreshInterval time.Duration
+	// 未知的key完整匹配其中任一正则时，自动创建并分配id，见 autocreate.go，为空时不自动创建
+	AutoCreatePatterns []string
+	// 自动创建时的step，为0时使用默认值 DefaultAutoCreateStep
+	AutoCreateStep int64
+	// 自动创建时的max_id，即起始值，为0时使用1
+	AutoCreateMaxId int64
+	// 计算下一个segment的step，为nil时使用 DefaultStepPolicy，TagStepPolicies 中的biz_tag优先
+	StepPolicy      StepPolicy
+	TagStepPolicies map[string]StepPolicy
+	// 当前segment消耗了多少比例后开始预加载，(0, 1)，为0时使用 DefaultPreloadRatio
+	PreloadRatio float64
+	// 每个biz_tag缓存的segment数量，[2, MaxSegments]，为0时使用 DefaultSegments
+	// 大于2时提前加载多个segment，repo不可用时可以支撑更长时间
+	Segments int
+}
+
 type segmentGen struct {
-	repo  repo.Repo
-	cache sync.Map
-	stop  chan struct{}
+	conf       Config
+	repo       repo.Repo
+	cache      sync.Map
+	stop       chan struct{}
+	done       chan struct{} // 定时刷新退出，且已将segmentBuf存入文件
+	refreshMu  sync.Mutex    // 定时刷新、管理接口触发的刷新和自动创建串行执行
+	autoCreate []*regexp.Regexp
 }
 
-func New(repo repo.Repo) service.IdGenerator {
+func New(repo repo.Repo, conf Config) service.IdGenerator {
 	g := &segmentGen{
+		conf: conf,
 		repo: repo,
 		stop: make(chan struct{}),
 	}
@@ -23,17 +55,38 @@ func New(repo repo.Repo) service.IdGenerator {
 }
 
 func (s *segmentGen) Init() error {
+	// 为1时当前segment用完才加载下一个，每次切换都要等待同步加载
+	if s.conf.PreloadRatio < 0 || s.conf.PreloadRatio >= 1 {
+		return fmt.Errorf("invalid preload ratio:%v, should be in (0, 1)", s.conf.PreloadRatio)
+	}
+	if s.conf.Segments != 0 && (s.conf.Segments < 2 || s.conf.Segments > MaxSegments) {
+		return fmt.Errorf("invalid segments:%d, should be in [2, %d]", s.conf.Segments, MaxSegments)
+	}
+	if err := s.initAutoCreate(); err != nil {
+		return err
+	}
 	// 初始化时先加载一次
 	if err := s.updateCacheFromRepo(); err != nil {
 		return err
 	}
-	go s.updatePeriodically(time.Minute)
+	interval := s.conf.RefreshInterval
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+	s.done = make(chan struct{})
+	go s.updatePeriodically(interval)
+	metrics.SetSegmentRunwayFunc(s.runway)
 	return nil
 }
 
 func (s *segmentGen) updatePeriodically(interval time.Duration) {
 	tick := time.NewTicker(interval)
 	defer tick.Stop()
+	defer close(s.done)
+	var notify <-chan struct{} // 为nil时不会被选中
+	if n, ok := s.repo.(repo.TagNotifier); ok {
+		notify = n.WatchTags(s.stop)
+	}
 
 	for {
 		select {
@@ -46,27 +99,41 @@ func (s *segmentGen) updatePeriodically(interval time.Duration) {
 			return
 		case <-tick.C:
 			_ = s.updateCacheFromRepo()
+		case <-notify:
+			_ = s.updateCacheFromRepo()
 		}
 	}
 }
 
 func (s *segmentGen) updateCacheFromRepo() error {
-	allKeys, err := s.repo.GetAllKeys()
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+	tags, err := s.repo.GetAllTags()
 	if err != nil {
+		log.Print("[segment] refresh tags failed. err:%v", err)
 		return err
 	}
 
 	allKeysSet := map[string]struct{}{}
-	for _, key := range allKeys {
-		allKeysSet[key] = struct{}{}
-		if _, ok := s.cache.Load(key); !ok { // 新增的key
-			sb := newSegmentBuf(key, s.repo) // 在这里初始化segmentBuf比较好
-			s.cache.Store(key, sb)
+	for _, tag := range tags {
+		allKeysSet[tag.Key] = struct{}{}
+		value, ok := s.cache.Load(tag.Key)
+		if !ok { // 新增的key
+			sb := newSegmentBuf(tag.Key, s.repo, s.bufOptions(tag.Key)) // 在这里初始化segmentBuf比较好
+			s.cache.Store(tag.Key, sb)
+			continue
+		}
+		// 每次刷新都比较step，max_id的变化在加载下一个segment时生效，description不影响分配
+		if value.(*segmentBuf).syncStep(tag.Step) {
+			log.Print("[segment] step of tag:%s changed in repo, step:%d", tag.Key, tag.Step)
 		}
 	}
 	s.cache.Range(func(key, value interface{}) bool {
 		if _, ok := allKeysSet[key.(string)]; !ok { // repo中已删除的key
 			s.cache.Delete(key)
+			value.(*segmentBuf).remove()
+			metrics.DeleteKey(key.(string))
+			log.Print("[segment] tag:%s removed from repo", key)
 		}
 		return true
 	})
@@ -76,18 +143,123 @@ func (s *segmentGen) updateCacheFromRepo() error {
 func (s *segmentGen) Gen(key string) (id int64, err error) {
 	select {
 	case <-s.stop:
+		metrics.IdErrors.WithLabelValues(metrics.ModeSegment, "", metrics.ErrorClosed).Inc()
 		return -1, fmt.Errorf("server closed")
 	default:
 	}
-	sb, ok := s.cache.Load(key)
-	if !ok {
-		id = -1
-		err = fmt.Errorf("not support key:%s", key)
-		return
+	sb, err := s.getSegmentBuf(key)
+	if err != nil {
+		return -1, err
+	}
+	if id, err = sb.nextId(); err == nil {
+		metrics.IdsIssued.WithLabelValues(metrics.ModeSegment, key).Inc()
+	}
+	return
+}
+
+func (s *segmentGen) GenBatch(key string, n int) (ids []int64, err error) {
+	if n <= 0 || n > service.MaxBatchSize {
+		metrics.IdErrors.WithLabelValues(metrics.ModeSegment, "", metrics.ErrorInvalidCount).Inc()
+		return nil, fmt.Errorf("%w, invalid count:%d", service.ErrInvalidArgument, n)
+	}
+	select {
+	case <-s.stop:
+		metrics.IdErrors.WithLabelValues(metrics.ModeSegment, "", metrics.ErrorClosed).Inc()
+		return nil, fmt.Errorf("server closed")
+	default:
+	}
+	sb, err := s.getSegmentBuf(key)
+	if err != nil {
+		return nil, err
+	}
+	if ids, err = sb.nextIds(int64(n)); err == nil {
+		metrics.IdsIssued.WithLabelValues(metrics.ModeSegment, key).Add(float64(len(ids)))
+	}
+	return
+}
+
+func (s *segmentGen) bufOptions(key string) bufOptions {
+	opts := bufOptions{policy: s.conf.StepPolicy, segments: s.conf.Segments, preloadRatio: s.conf.PreloadRatio}
+	if p, ok := s.conf.TagStepPolicies[key]; ok {
+		opts.policy = p
+	}
+	return opts
+}
+
+// 未缓存的key匹配自动创建的规则时，在repo中创建
+func (s *segmentGen) getSegmentBuf(key string) (*segmentBuf, error) {
+	if sb, ok := s.cache.Load(key); ok {
+		return sb.(*segmentBuf), nil
+	}
+	if !s.autoCreatable(key) {
+		metrics.IdErrors.WithLabelValues(metrics.ModeSegment, "", metrics.ErrorUnknownKey).Inc()
+		return nil, fmt.Errorf("%w:%s", service.ErrUnknownKey, key)
+	}
+	sb, err := s.createSegmentBuf(key)
+	if err != nil {
+		metrics.IdErrors.WithLabelValues(metrics.ModeSegment, "", metrics.ErrorRepo).Inc()
+		return nil, fmt.Errorf("auto create key:%s failed. err:%v", key, err)
+	}
+	return sb, nil
+}
+
+// 实现 service.ReadinessChecker: repo可以访问，且所有key的segmentBuf都已初始化
+func (s *segmentGen) Ready() error {
+	select {
+	case <-s.stop:
+		return fmt.Errorf("server closed")
+	default:
+	}
+	if _, err := s.repo.GetAllKeys(); err != nil {
+		return fmt.Errorf("repo unreachable. err:%v", err)
 	}
-	return sb.(*segmentBuf).nextId()
+	var notReady []string
+	s.cache.Range(func(key, value interface{}) bool {
+		if !value.(*segmentBuf).status().InitOk {
+			notReady = append(notReady, key.(string))
+		}
+		return true
+	})
+	if len(notReady) > 0 {
+		sort.Strings(notReady)
+		return fmt.Errorf("segment buf not initialized, keys:%s", strings.Join(notReady, ","))
+	}
+	return nil
 }
 
+func (s *segmentGen) runway() []metrics.SegmentRunway {
+	select {
+	case <-s.stop:
+		return nil
+	default:
+	}
+	var runways []metrics.SegmentRunway
+	s.cache.Range(func(key, value interface{}) bool {
+		if ids, seconds, ok := value.(*segmentBuf).runway(); ok {
+			runways = append(runways, metrics.SegmentRunway{Key: key.(string), BufferedIds: ids, Seconds: seconds})
+		}
+		return true
+	})
+	return runways
+}
+
+// 实现 service.StatusReporter，各key的segmentBuf状态
+func (s *segmentGen) Status() map[string]interface{} {
+	bufs := map[string]segmentBufStatus{}
+	s.cache.Range(func(key, value interface{}) bool {
+		bufs[key.(string)] = value.(*segmentBuf).status()
+		return true
+	})
+	return map[string]interface{}{
+		"mode": "segment",
+		"bufs": bufs,
+	}
+}
+
+// 等待segmentBuf存入文件后返回
 func (s *segmentGen) Shutdown() {
 	close(s.stop)
+	if s.done != nil {
+		<-s.done
+	}
 }
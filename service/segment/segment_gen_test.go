@@ -0,0 +1,278 @@
+package segment
+
+import (
+	"errors"
+	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/entity"
+	"github.com/longyufei109/leaf-go/metrics"
+	"github.com/longyufei109/leaf-go/repo"
+	"github.com/longyufei109/leaf-go/service"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSegmentGen_ReadyAndStatus(t *testing.T) {
+	r := newMemRepo(100, "a", "b")
+	g := New(r, Config{}).(*segmentGen)
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Ready(); err != nil {
+		t.Fatalf("expect ready, err:%v", err)
+	}
+	if _, err := g.GenBatch("a", 30); err != nil {
+		t.Fatal(err)
+	}
+	bufs := g.Status()["bufs"].(map[string]segmentBufStatus)
+	if a := bufs["a"]; len(bufs) != 2 || !a.InitOk || a.Step != 100 || a.MinStep != 100 || a.Idle != 70 {
+		t.Fatalf("unexpected status:%+v", bufs)
+	}
+
+	r.mu.Lock()
+	r.err = errors.New("connection refused")
+	r.mu.Unlock()
+	if err := g.Ready(); err == nil {
+		t.Fatal("expect not ready when repo unreachable")
+	}
+}
+
+func TestSegmentGen_Metrics(t *testing.T) {
+	g := New(newMemRepo(100, "metrics"), Config{}).(*segmentGen)
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := g.GenBatch("metrics", 50); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, _ = g.Gen("unknown")
+
+	if v := testutil.ToFloat64(metrics.IdsIssued.WithLabelValues(metrics.ModeSegment, "metrics")); v != 250 {
+		t.Fatalf("expect 250 ids issued, got %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.SegmentSwitches.WithLabelValues("metrics")); v < 1 {
+		t.Fatalf("expect segment switches, got %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.SegmentStep.WithLabelValues("metrics")); v < 100 {
+		t.Fatalf("unexpected step %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.IdErrors.WithLabelValues(metrics.ModeSegment, "", metrics.ErrorUnknownKey)); v < 1 {
+		t.Fatalf("expect unknown key errors, got %v", v)
+	}
+}
+
+func TestSegmentGen_Tags(t *testing.T) {
+	g := New(newMemRepo(100, "a"), Config{}).(*segmentGen)
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.CreateTag(entity.Tag{Key: "b", Step: 0}); err == nil {
+		t.Fatal("expect invalid step error")
+	}
+	if err := g.CreateTag(entity.Tag{Key: "b", Step: 10}); err != nil {
+		t.Fatal(err)
+	}
+	// 无需等待定时刷新
+	if id, err := g.Gen("b"); err != nil || id != 1 {
+		t.Fatalf("unexpected id:%d, err:%v", id, err)
+	}
+	if err := g.CreateTag(entity.Tag{Key: "b", Step: 10}); err != repo.ErrTagExists {
+		t.Fatalf("expect ErrTagExists, got %v", err)
+	}
+
+	if err := g.UpdateTagStep("b", 1000); err != nil {
+		t.Fatal(err)
+	}
+	if st := g.Status()["bufs"].(map[string]segmentBufStatus)["b"]; st.Step != 1000 || st.MinStep != 1000 {
+		t.Fatalf("unexpected status:%+v", st)
+	}
+	if err := g.UpdateTagStep("c", 1000); err != repo.ErrTagNotFound {
+		t.Fatalf("expect ErrTagNotFound, got %v", err)
+	}
+
+	if err := g.DeleteTag("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Gen("b"); err == nil {
+		t.Fatal("expect error after tag deleted")
+	}
+}
+
+func TestSegmentGen_AutoCreate(t *testing.T) {
+	if err := New(newMemRepo(100), Config{AutoCreatePatterns: []string{"("}}).Init(); err == nil {
+		t.Fatal("expect invalid pattern error")
+	}
+
+	// 两个节点共用一个repo，并发请求同一个未知的key
+	r := newMemRepo(100)
+	conf := Config{AutoCreatePatterns: []string{`order_\d+`, "user"}, AutoCreateStep: 500, AutoCreateMaxId: 1000}
+	var gens []*segmentGen
+	for i := 0; i < 2; i++ {
+		g := New(r, conf).(*segmentGen)
+		if err := g.Init(); err != nil {
+			t.Fatal(err)
+		}
+		defer g.Shutdown()
+		gens = append(gens, g)
+	}
+	var wg sync.WaitGroup
+	results := make(chan int64, 200)
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(g *segmentGen) {
+			defer wg.Done()
+			id, err := g.Gen("order_1")
+			if err != nil {
+				t.Error(err)
+			}
+			results <- id
+		}(gens[i%2])
+	}
+	wg.Wait()
+	close(results)
+	seen := map[int64]bool{}
+	for id := range results {
+		if seen[id] || id < 1000 {
+			t.Fatalf("unexpected id:%d", id)
+		}
+		seen[id] = true
+	}
+	if tags, _ := r.GetAllTags(); len(tags) != 1 || tags[0].Step != 500 {
+		t.Fatalf("unexpected tags:%+v", tags)
+	}
+
+	for _, key := range []string{"order_x", "order_1x", "users", ""} {
+		if _, err := gens[0].Gen(key); err == nil {
+			t.Fatalf("key:%q, expect not support error", key)
+		}
+	}
+	if ids, err := gens[1].GenBatch("user", 10); err != nil || len(ids) != 10 {
+		t.Fatalf("unexpected ids:%v, err:%v", ids, err)
+	}
+
+	// 删除后会被重新创建并重复分配id
+	if err := gens[0].DeleteTag("order_1"); !errors.Is(err, service.ErrInvalidArgument) {
+		t.Fatalf("expect ErrInvalidArgument, got %v", err)
+	}
+	if id, err := gens[1].Gen("order_1"); err != nil || seen[id] {
+		t.Fatalf("unexpected id:%d, err:%v", id, err)
+	}
+}
+
+func TestSegmentGen_Segments(t *testing.T) {
+	for _, c := range []Config{{PreloadRatio: -0.1}, {PreloadRatio: 1}, {PreloadRatio: 1.5}, {Segments: 1}, {Segments: MaxSegments + 1}} {
+		if err := New(newMemRepo(100), c).Init(); err == nil {
+			t.Fatalf("config:%+v, expect error", c)
+		}
+	}
+
+	r := newMemRepo(100, "runway")
+	g := New(r, Config{Segments: 4, PreloadRatio: 0.5}).(*segmentGen)
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Shutdown()
+	// 消耗不到一半时不预加载
+	if _, err := g.GenBatch("runway", 40); err != nil {
+		t.Fatal(err)
+	}
+	if st := g.Status()["bufs"].(map[string]segmentBufStatus)["runway"]; st.Ready != 0 || st.IsLoadingNext {
+		t.Fatalf("unexpected status:%+v", st)
+	}
+	waitFor(t, func() bool {
+		_, _ = g.Gen("runway")
+		return g.Status()["bufs"].(map[string]segmentBufStatus)["runway"].Ready == 3
+	}, "segments not loaded")
+
+	runways := g.runway()
+	if len(runways) != 1 || runways[0].Key != "runway" || runways[0].BufferedIds <= 300 || runways[0].Seconds <= 0 {
+		t.Fatalf("unexpected runways:%+v", runways)
+	}
+	if n, err := testutil.GatherAndCount(metrics.Registry, "leaf_segment_buffered_ids", "leaf_segment_runway_seconds"); err != nil || n != 2 {
+		t.Fatalf("expect 2 runway metrics, got %d, err:%v", n, err)
+	}
+
+	// repo不可用时继续使用已加载的segment
+	r.mu.Lock()
+	r.segErr = errors.New("connection refused")
+	r.mu.Unlock()
+	seen := map[int64]bool{}
+	for i := int64(0); i < runways[0].BufferedIds; i++ {
+		id, err := g.Gen("runway")
+		if err != nil || seen[id] {
+			t.Fatalf("unexpected id:%d, err:%v", id, err)
+		}
+		seen[id] = true
+	}
+	if _, err := g.Gen("runway"); err == nil {
+		t.Fatal("expect error when all segments exhausted")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool, msg string) {
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSegmentGen_Refresh(t *testing.T) {
+	originDir := config.Global.Segment.CacheDir
+	config.Global.Segment.CacheDir = t.TempDir()
+	defer func() { config.Global.Segment.CacheDir = originDir }()
+
+	// 定时刷新时发现repo中step的变化
+	r := newMemRepo(100, "a")
+	r.notify = nil
+	g := New(r, Config{RefreshInterval: 50 * time.Millisecond}).(*segmentGen)
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Shutdown()
+	r.mu.Lock()
+	r.steps["a"] = 300
+	r.mu.Unlock()
+	waitFor(t, func() bool {
+		a := g.Status()["bufs"].(map[string]segmentBufStatus)["a"]
+		return a.Step == 300 && a.MinStep == 300
+	}, "step not reset after changed in repo")
+
+	// 收到通知后立即刷新，删除的key停止分配并删除缓存文件
+	r = newMemRepo(100, "a", "b")
+	g = New(r, Config{RefreshInterval: time.Hour}).(*segmentGen)
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Shutdown()
+	if _, err := g.Gen("b"); err != nil {
+		t.Fatal(err)
+	}
+	value, _ := g.cache.Load("b")
+	sb := value.(*segmentBuf)
+	if err := os.WriteFile(sb.cacheFile(), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteTag("b"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		_, err := os.Stat(sb.cacheFile())
+		return os.IsNotExist(err)
+	}, "cache file not deleted after tag removed")
+	if _, err := g.Gen("b"); err == nil {
+		t.Fatal("expect error after tag removed")
+	}
+	if _, err := sb.nextId(); err == nil {
+		t.Fatal("expect removed segment buf stopped")
+	}
+	if _, err := g.Gen("a"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,118 @@
+package segment
+
+import (
+	"fmt"
+	"github.com/longyufei109/leaf-go/config"
+	"time"
+)
+
+// 加载下一个segment时，由 StepPolicy 计算其step
+type StepPolicy interface {
+	NextStep(c StepContext) int64
+}
+
+type StepContext struct {
+	Key     string
+	Step    int64 // 当前step
+	MinStep int64 // repo中的step
+	// 距离上次从repo获取segment的时长
+	SinceLastUpdate time.Duration
+	// 当前segment已分配的id数量，及其开始使用到现在的时长
+	Consumed         int64
+	ConsumedDuration time.Duration
+}
+
+// 未配置时使用，与 SegmentDurationSeconds、MaxStep 一致
+var DefaultStepPolicy StepPolicy = &AdaptiveStepPolicy{}
+
+// 按获取segment的间隔倍增或缩小step:
+// 间隔小于 TargetDuration 时乘以 Factor，但不超过 MaxStep
+// 间隔大于 2*TargetDuration 时除以 Factor，但不小于 MinStep
+type AdaptiveStepPolicy struct {
+	TargetDuration time.Duration // 为0时使用 SegmentDurationSeconds
+	MinStep        int64         // 为0时使用repo中的step
+	MaxStep        int64         // 为0时使用 MaxStep
+	Factor         float64       // 大于1，为0时使用2
+}
+
+func (p *AdaptiveStepPolicy) NextStep(c StepContext) int64 {
+	target, minStep, maxStep := stepBounds(p.TargetDuration, p.MinStep, p.MaxStep, c)
+	factor := p.Factor
+	if factor == 0 {
+		factor = 2
+	}
+	step := c.Step
+	if c.SinceLastUpdate < target {
+		if next := int64(float64(step) * factor); next < maxStep {
+			step = next
+		}
+	} else if c.SinceLastUpdate > 2*target {
+		if next := int64(float64(step) / factor); next > minStep {
+			step = next
+		}
+	}
+	return step
+}
+
+// 按当前segment的消耗速度预测，使下一个segment约能使用 TargetDuration，结果在[MinStep, MaxStep]之间
+// 适合流量变化大的biz_tag，倍增需要多次加载才能追上流量
+type QpsStepPolicy struct {
+	TargetDuration time.Duration // 为0时使用 SegmentDurationSeconds
+	MinStep        int64         // 为0时使用repo中的step
+	MaxStep        int64         // 为0时使用 MaxStep
+}
+
+func (p *QpsStepPolicy) NextStep(c StepContext) int64 {
+	target, minStep, maxStep := stepBounds(p.TargetDuration, p.MinStep, p.MaxStep, c)
+	step := c.Step
+	if c.Consumed > 0 && c.ConsumedDuration > 0 {
+		qps := float64(c.Consumed) / c.ConsumedDuration.Seconds()
+		step = int64(qps * target.Seconds())
+	}
+	if step < minStep {
+		step = minStep
+	}
+	if step > maxStep {
+		step = maxStep
+	}
+	return step
+}
+
+func stepBounds(target time.Duration, minStep, maxStep int64, c StepContext) (time.Duration, int64, int64) {
+	if target == 0 {
+		target = SegmentDurationSeconds * time.Second
+	}
+	if minStep == 0 {
+		minStep = c.MinStep
+	}
+	if maxStep == 0 {
+		maxStep = MaxStep
+	}
+	return target, minStep, maxStep
+}
+
+const (
+	StepPolicyAdaptive = 1
+	StepPolicyQps      = 2
+)
+
+// 由配置创建 StepPolicy
+func NewStepPolicy(c config.SegmentStepPolicy) (StepPolicy, error) {
+	if c.TargetDuration < 0 || c.MinStep < 0 || c.MaxStep < 0 {
+		return nil, fmt.Errorf("invalid step policy:%+v, negative value", c)
+	}
+	if c.MaxStep > 0 && c.MinStep > c.MaxStep {
+		return nil, fmt.Errorf("invalid step policy:%+v, minStep is greater than maxStep", c)
+	}
+	target := time.Duration(c.TargetDuration) * time.Second
+	switch c.Mode {
+	case 0, StepPolicyAdaptive:
+		if c.Factor != 0 && c.Factor <= 1 {
+			return nil, fmt.Errorf("invalid step policy:%+v, factor should be greater than 1", c)
+		}
+		return &AdaptiveStepPolicy{TargetDuration: target, MinStep: c.MinStep, MaxStep: c.MaxStep, Factor: c.Factor}, nil
+	case StepPolicyQps:
+		return &QpsStepPolicy{TargetDuration: target, MinStep: c.MinStep, MaxStep: c.MaxStep}, nil
+	}
+	return nil, fmt.Errorf("not support step policy mode:%d", c.Mode)
+}
@@ -0,0 +1,100 @@
+package segment
+
+import (
+	"github.com/longyufei109/leaf-go/config"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveStepPolicy(t *testing.T) {
+	cases := []struct {
+		policy AdaptiveStepPolicy
+		ctx    StepContext
+		step   int64
+	}{
+		// 默认与原有逻辑一致
+		{AdaptiveStepPolicy{}, StepContext{Step: 1000, MinStep: 1000, SinceLastUpdate: time.Minute}, 2000},
+		{AdaptiveStepPolicy{}, StepContext{Step: 1000, MinStep: 1000, SinceLastUpdate: 20 * time.Minute}, 1000},
+		{AdaptiveStepPolicy{}, StepContext{Step: 4000, MinStep: 1000, SinceLastUpdate: time.Hour}, 2000},
+		{AdaptiveStepPolicy{}, StepContext{Step: 2000, MinStep: 1000, SinceLastUpdate: time.Hour}, 2000},
+		{AdaptiveStepPolicy{}, StepContext{Step: 600000, MinStep: 1000, SinceLastUpdate: time.Minute}, 600000},
+		// 自定义
+		{AdaptiveStepPolicy{MaxStep: 1e7}, StepContext{Step: 600000, SinceLastUpdate: time.Minute}, 1200000},
+		{AdaptiveStepPolicy{TargetDuration: time.Minute}, StepContext{Step: 1000, SinceLastUpdate: 2 * time.Minute}, 1000},
+		{AdaptiveStepPolicy{TargetDuration: time.Minute, MinStep: 100}, StepContext{Step: 1000, MinStep: 1000, SinceLastUpdate: 3 * time.Minute}, 500},
+		{AdaptiveStepPolicy{Factor: 4}, StepContext{Step: 1000, SinceLastUpdate: time.Minute}, 4000},
+	}
+	for _, c := range cases {
+		if step := c.policy.NextStep(c.ctx); step != c.step {
+			t.Fatalf("policy:%+v, ctx:%+v, expect %d, got %d", c.policy, c.ctx, c.step, step)
+		}
+	}
+}
+
+func TestQpsStepPolicy(t *testing.T) {
+	cases := []struct {
+		policy QpsStepPolicy
+		ctx    StepContext
+		step   int64
+	}{
+		// 100 qps，期望使用 900s
+		{QpsStepPolicy{}, StepContext{Step: 1000, MinStep: 1000, Consumed: 1000, ConsumedDuration: 10 * time.Second}, 90000},
+		{QpsStepPolicy{TargetDuration: time.Minute}, StepContext{Step: 1000, MinStep: 1000, Consumed: 1000, ConsumedDuration: 10 * time.Second}, 6000},
+		// 不超过上下限
+		{QpsStepPolicy{}, StepContext{Step: 1000, MinStep: 1000, Consumed: 1, ConsumedDuration: time.Hour}, 1000},
+		{QpsStepPolicy{MinStep: 10}, StepContext{Step: 1000, MinStep: 1000, Consumed: 1, ConsumedDuration: time.Hour}, 10},
+		{QpsStepPolicy{}, StepContext{Step: 1000, Consumed: 1e6, ConsumedDuration: time.Second}, MaxStep},
+		{QpsStepPolicy{MaxStep: 1e9}, StepContext{Step: 1000, Consumed: 1e6, ConsumedDuration: time.Second}, 9e8},
+		// 没有消耗时保持不变
+		{QpsStepPolicy{}, StepContext{Step: 3000, MinStep: 1000}, 3000},
+	}
+	for _, c := range cases {
+		if step := c.policy.NextStep(c.ctx); step != c.step {
+			t.Fatalf("policy:%+v, ctx:%+v, expect %d, got %d", c.policy, c.ctx, c.step, step)
+		}
+	}
+}
+
+func TestNewStepPolicy(t *testing.T) {
+	if p, err := NewStepPolicy(config.SegmentStepPolicy{}); err != nil || *p.(*AdaptiveStepPolicy) != (AdaptiveStepPolicy{}) {
+		t.Fatalf("unexpected policy:%+v, err:%v", p, err)
+	}
+	p, err := NewStepPolicy(config.SegmentStepPolicy{Mode: StepPolicyQps, TargetDuration: 60, MaxStep: 1e7})
+	if err != nil || *p.(*QpsStepPolicy) != (QpsStepPolicy{TargetDuration: time.Minute, MaxStep: 1e7}) {
+		t.Fatalf("unexpected policy:%+v, err:%v", p, err)
+	}
+	for _, c := range []config.SegmentStepPolicy{{Mode: 3}, {Factor: 0.5}, {MinStep: 100, MaxStep: 10}, {TargetDuration: -1}} {
+		if _, err = NewStepPolicy(c); err == nil {
+			t.Fatalf("config:%+v, expect error", c)
+		}
+	}
+}
+
+type recordPolicy struct {
+	ctx StepContext
+}
+
+func (p *recordPolicy) NextStep(c StepContext) int64 {
+	p.ctx = c
+	return 500
+}
+
+func TestSegmentBuf_StepPolicy(t *testing.T) {
+	p := &recordPolicy{}
+	sb := newSegmentBuf("policy", newMemRepo(100, "policy"), bufOptions{policy: p})
+	if _, err := sb.nextIds(20); err != nil {
+		t.Fatal(err)
+	}
+	// 消耗超过10%后，下次分配时预加载下一个segment
+	if _, err := sb.nextId(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return sb.status().IsNextReady }, "next segment not loaded")
+	if p.ctx.Key != "policy" || p.ctx.Step != 100 || p.ctx.MinStep != 100 || p.ctx.Consumed < 20 ||
+		p.ctx.ConsumedDuration <= 0 {
+		t.Fatalf("unexpected step context:%+v", p.ctx)
+	}
+	if st := sb.status(); st.Step != 500 || st.Segments[1].Step != 500 {
+		t.Fatalf("unexpected status:%+v", st)
+	}
+}
@@ -0,0 +1,76 @@
+package segment
+
+import (
+	"fmt"
+	"github.com/longyufei109/leaf-go/entity"
+	"github.com/longyufei109/leaf-go/log"
+	"github.com/longyufei109/leaf-go/service"
+)
+
+const maxKeyLength = 128 // leaf_alloc.biz_tag 的长度
+
+// 实现 service.TagManager
+
+func (s *segmentGen) Tags() ([]entity.Tag, error) {
+	return s.repo.GetAllTags()
+}
+
+// MaxId 为0时使用1，与leaf_alloc表的默认值一致
+func (s *segmentGen) CreateTag(tag entity.Tag) error {
+	if tag.Key == "" || len(tag.Key) > maxKeyLength {
+		return fmt.Errorf("%w, key:%q length should be in [1, %d]", service.ErrInvalidArgument, tag.Key, maxKeyLength)
+	}
+	if err := checkStep(tag.Step); err != nil {
+		return err
+	}
+	if tag.MaxId < 0 {
+		return fmt.Errorf("%w, max_id:%d should not be negative", service.ErrInvalidArgument, tag.MaxId)
+	}
+	if tag.MaxId == 0 {
+		tag.MaxId = 1
+	}
+	if err := s.repo.CreateTag(tag); err != nil {
+		return err
+	}
+	log.Print("[segment] create tag:%s, max_id:%d, step:%d", tag.Key, tag.MaxId, tag.Step)
+	return s.updateCacheFromRepo()
+}
+
+// 已加载的segment不变，从加载下一个segment开始使用新的step
+func (s *segmentGen) UpdateTagStep(key string, step int64) error {
+	if err := checkStep(step); err != nil {
+		return err
+	}
+	if err := s.repo.UpdateStep(key, step); err != nil {
+		return err
+	}
+	log.Print("[segment] update step of tag:%s, step:%d", key, step)
+	if sb, ok := s.cache.Load(key); ok {
+		sb.(*segmentBuf).resetStep(step)
+	}
+	return nil
+}
+
+func (s *segmentGen) UpdateTagDescription(key string, description string) error {
+	return s.repo.UpdateDescription(key, description)
+}
+
+// 已分配的id不会再次分配，之后以同样的key创建时需指定大于已分配的 max_id
+// 匹配自动创建规则的key不能删除，否则之后请求时以 AutoCreateMaxId 重新创建，重复分配id
+func (s *segmentGen) DeleteTag(key string) error {
+	if s.autoCreatable(key) {
+		return fmt.Errorf("%w, key:%s matches auto create patterns", service.ErrInvalidArgument, key)
+	}
+	if err := s.repo.DeleteTag(key); err != nil {
+		return err
+	}
+	log.Print("[segment] delete tag:%s", key)
+	return s.updateCacheFromRepo()
+}
+
+func checkStep(step int64) error {
+	if step <= 0 || step > MaxStep {
+		return fmt.Errorf("%w, step:%d should be in [1, %d]", service.ErrInvalidArgument, step, int64(MaxStep))
+	}
+	return nil
+}
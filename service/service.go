@@ -1,7 +1,56 @@
 package service
 
+import (
+	"errors"
+	"github.com/longyufei109/leaf-go/entity"
+)
+
+// GenBatch 单次最多分配的id数量
+const MaxBatchSize = 10000
+
+var ErrInvalidArgument = errors.New("invalid argument")
+
+// segment模式下key不存在，且不能自动创建
+var ErrUnknownKey = errors.New("not support key")
+
 type IdGenerator interface {
 	Init() error
 	Gen(key string) (id int64, err error)
+	// 一次分配n个id，n的取值范围为[1, MaxBatchSize]
+	GenBatch(key string, n int) (ids []int64, err error)
 	Shutdown()
 }
+
+// 能够将id解析为各组成部分的IdGenerator需实现该接口，目前只有snowflake模式可以
+type Decoder interface {
+	Decode(id int64) (entity.IdInfo, error)
+}
+
+// IdGenerator 可选实现，用于健康检查接口
+type HealthChecker interface {
+	// 不健康时返回错误，此时无法分配id
+	Healthy() error
+	// 展示用的详细信息，可以为nil
+	HealthDetails() map[string]interface{}
+}
+
+// IdGenerator 可选实现，用于就绪检查接口
+type ReadinessChecker interface {
+	// 无法分配id时返回原因
+	Ready() error
+}
+
+// IdGenerator 可选实现，用于状态接口，返回值需能序列化为json
+type StatusReporter interface {
+	Status() map[string]interface{}
+}
+
+// IdGenerator 可选实现，segment模式下biz_tag的管理，修改后立即刷新缓存
+// 不存在、已存在时分别返回 repo.ErrTagNotFound、repo.ErrTagExists，参数不合法时返回的错误包装了 ErrInvalidArgument
+type TagManager interface {
+	Tags() ([]entity.Tag, error)
+	CreateTag(tag entity.Tag) error
+	UpdateTagStep(key string, step int64) error
+	UpdateTagDescription(key string, description string) error
+	DeleteTag(key string) error
+}
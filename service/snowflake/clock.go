@@ -0,0 +1,155 @@
+package snowflake
+
+import (
+	"fmt"
+	"github.com/longyufei109/leaf-go/log"
+	"github.com/longyufei109/leaf-go/metrics"
+	"github.com/longyufei109/leaf-go/util"
+	"time"
+)
+
+// 时钟回拨时的处理策略
+type ClockPolicy int
+
+const (
+	ClockPolicyWait        ClockPolicy = 0 // 回拨不超过 MaxBackwardMs 时等待时钟追上，否则失败
+	ClockPolicyFailFast    ClockPolicy = 1 // 直接失败
+	ClockPolicyBorrow      ClockPolicy = 2 // 继续使用上次的时间戳(逻辑时钟领先于系统时间)，领先不超过 MaxBackwardMs
+	ClockPolicySpareWorker ClockPolicy = 3 // 切换到一个尚未使用过当前时间的备用workerId
+)
+
+const defaultMaxBackwardMs int64 = 5
+
+// 各处理路径的累计次数，按生成id的调用计数
+type ClockStats struct {
+	Backwards      int64 // 检测到时钟回拨
+	Waited         int64 // 等待时钟追上
+	Borrowed       int64 // 借用未来的时间戳
+	WorkerSwitched int64 // 切换到备用workerId
+	Failed         int64 // 无法处理，返回 *ClockBackwardsError
+}
+
+type clockEvent int
+
+const (
+	clockBackwards clockEvent = iota
+	clockWaited
+	clockBorrowed
+	clockWorkerSwitched
+	clockFailed
+	numClockEvents
+)
+
+// metrics.SnowflakeClockEvents 的 event 标签
+var clockEventNames = [numClockEvents]string{"backwards", "waited", "borrowed", "worker_switched", "failed"}
+
+type clockStats [numClockEvents]util.AtomicInt64
+
+// 同时计入 metrics.SnowflakeClockEvents
+func (c *clockStats) inc(e clockEvent) {
+	c[e].Add(1)
+	metrics.SnowflakeClockEvents.WithLabelValues(clockEventNames[e]).Inc()
+}
+
+func (s *snowflake) ClockStats() ClockStats {
+	return ClockStats{
+		Backwards:      s.stats[clockBackwards].Value(),
+		Waited:         s.stats[clockWaited].Value(),
+		Borrowed:       s.stats[clockBorrowed].Value(),
+		WorkerSwitched: s.stats[clockWorkerSwitched].Value(),
+		Failed:         s.stats[clockFailed].Value(),
+	}
+}
+
+func (s *snowflake) initClockPolicy() error {
+	switch s.conf.ClockPolicy {
+	case ClockPolicyWait, ClockPolicyFailFast, ClockPolicyBorrow:
+	case ClockPolicySpareWorker:
+		// 备用workerId是静态配置的，没有通过 WorkerIdProvider 占用，可能已分配给其它节点
+		if s.conf.WorkerIdProvider != nil {
+			return fmt.Errorf("clock policy %d is not supported with WorkerIdProvider", s.conf.ClockPolicy)
+		}
+		if len(s.conf.SpareWorkerIds) == 0 {
+			return fmt.Errorf("no spare workerId for clock policy %d", s.conf.ClockPolicy)
+		}
+		s.workerTimestamps = map[int64]int64{s.workerId: 0}
+		for _, id := range s.conf.SpareWorkerIds {
+			if id < 0 || id > s.layout.MaxWorkerId() {
+				return fmt.Errorf("invalid spare workerId:%d, should be in [0, %d]", id, s.layout.MaxWorkerId())
+			}
+			if id == s.workerId {
+				return fmt.Errorf("spare workerId %d is the same as the primary workerId", id)
+			}
+			if _, ok := s.workerTimestamps[id]; ok {
+				return fmt.Errorf("duplicate spare workerId:%d", id)
+			}
+			s.workerTimestamps[id] = 0
+		}
+	default:
+		return fmt.Errorf("invalid clock policy:%d", s.conf.ClockPolicy)
+	}
+	return nil
+}
+
+// 加锁保护时调用，now < s.lastTimestamp
+// 返回可以使用的时间戳，可能切换了s.workerId
+func (s *snowflake) handleClockBackwards(now int64) (int64, error) {
+	s.stats.inc(clockBackwards)
+	offset := s.lastTimestamp - now
+	switch s.conf.ClockPolicy {
+	case ClockPolicyWait:
+		if offset <= s.conf.MaxBackwardMs {
+			time.Sleep(time.Duration(offset) * time.Millisecond)
+			if now = curMilliseconds(); now >= s.lastTimestamp {
+				s.stats.inc(clockWaited)
+				return now, nil
+			}
+		}
+	case ClockPolicyBorrow:
+		if offset <= s.conf.MaxBackwardMs {
+			s.stats.inc(clockBorrowed)
+			return s.lastTimestamp, nil
+		}
+	case ClockPolicySpareWorker:
+		if s.switchWorker(now) {
+			s.stats.inc(clockWorkerSwitched)
+			return now, nil
+		}
+	}
+	s.stats.inc(clockFailed)
+	return -1, &ClockBackwardsError{Last: s.lastTimestamp, Now: now}
+}
+
+// 当前毫秒的序列号已用尽，返回下一个可用的时间戳
+func (s *snowflake) tilNextMillis() (int64, error) {
+	now := curMilliseconds()
+	if s.conf.ClockPolicy == ClockPolicyBorrow && now < s.lastTimestamp { // 正在借用未来的时间戳，继续借用
+		if s.lastTimestamp+1-now > s.conf.MaxBackwardMs {
+			s.stats.inc(clockFailed)
+			return -1, &ClockBackwardsError{Last: s.lastTimestamp, Now: now}
+		}
+		s.stats.inc(clockBorrowed)
+		return s.lastTimestamp + 1, nil
+	}
+	metrics.SnowflakeSequenceExhausted.Inc()
+	for now <= s.lastTimestamp { // 循环 直到 下一毫秒
+		now = curMilliseconds()
+	}
+	return now, nil
+}
+
+// 选择一个最后使用的时间戳小于now的workerId，优先使用主workerId
+// 各workerId的最后使用时间戳分别记录，保证切换回来时不会重复
+func (s *snowflake) switchWorker(now int64) bool {
+	s.workerTimestamps[s.workerId] = s.lastTimestamp
+	candidates := append([]int64{s.primaryWorkerId}, s.conf.SpareWorkerIds...)
+	for _, id := range candidates {
+		if id != s.workerId && s.workerTimestamps[id] < now {
+			log.Print("[snowflake] clock moved backwards %dms, switch workerId %d -> %d", s.lastTimestamp-now, s.workerId, id)
+			s.workerId = id
+			s.lastTimestamp = s.workerTimestamps[id]
+			return true
+		}
+	}
+	return false
+}
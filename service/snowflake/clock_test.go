@@ -0,0 +1,146 @@
+package snowflake
+
+import (
+	"github.com/longyufei109/leaf-go/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sync/atomic"
+	"testing"
+)
+
+// 将时钟固定为返回 *ms，返回恢复函数
+func fakeClock(ms *int64) func() {
+	origin := curMilliseconds
+	curMilliseconds = func() int64 {
+		return atomic.LoadInt64(ms)
+	}
+	return func() {
+		curMilliseconds = origin
+	}
+}
+
+func newClockTestSnowflake(t *testing.T, conf Config) *snowflake {
+	conf.WorkerIdGetter = getworkerId
+	g := New(conf)
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return g.(*snowflake)
+}
+
+func TestClockPolicy_FailFast(t *testing.T) {
+	now := curMilliseconds()
+	defer fakeClock(&now)()
+	s := newClockTestSnowflake(t, Config{ClockPolicy: ClockPolicyFailFast})
+
+	if _, err := s.Gen(""); err != nil {
+		t.Fatal(err)
+	}
+	atomic.AddInt64(&now, -1)
+	_, err := s.Gen("")
+	if _, ok := err.(*ClockBackwardsError); !ok {
+		t.Fatalf("expect *ClockBackwardsError, got %v", err)
+	}
+	if stats := s.ClockStats(); stats.Backwards != 1 || stats.Failed != 1 {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+	if v := testutil.ToFloat64(metrics.SnowflakeClockEvents.WithLabelValues("failed")); v < 1 {
+		t.Fatalf("expect failed clock events in metrics, got %v", v)
+	}
+}
+
+func TestClockPolicy_Wait(t *testing.T) {
+	now := curMilliseconds()
+	defer fakeClock(&now)()
+	s := newClockTestSnowflake(t, Config{ClockPolicy: ClockPolicyWait, MaxBackwardMs: 5})
+
+	_, _ = s.Gen("")
+	atomic.AddInt64(&now, -100) // 超过最大等待时间
+	if _, err := s.Gen(""); err == nil {
+		t.Fatal("expect error when clock moved backwards 100ms")
+	}
+	atomic.AddInt64(&now, 100)
+	if _, err := s.Gen(""); err != nil {
+		t.Fatal(err)
+	}
+	if stats := s.ClockStats(); stats.Backwards != 1 || stats.Failed != 1 {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+}
+
+func TestClockPolicy_Borrow(t *testing.T) {
+	now := curMilliseconds()
+	defer fakeClock(&now)()
+	s := newClockTestSnowflake(t, Config{ClockPolicy: ClockPolicyBorrow, MaxBackwardMs: 3})
+
+	last, _ := s.Gen("")
+	atomic.AddInt64(&now, -2)
+	// 序列号用尽后继续借用下一毫秒，直到领先系统时间超过3ms
+	var ids []int64
+	for {
+		id, err := s.Gen("")
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 || ids[0] <= last {
+		t.Fatalf("expect borrowed ids after %d", last)
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Fatalf("ids not increasing, %d <= %d", ids[i], ids[i-1])
+		}
+	}
+	if ts := Decode(ids[len(ids)-1], 0).Timestamp; ts != now+3 {
+		t.Fatalf("expect last borrowed timestamp %d, got %d", now+3, ts)
+	}
+	if stats := s.ClockStats(); stats.Borrowed == 0 || stats.Failed != 1 {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+}
+
+func TestClockPolicy_SpareWorker(t *testing.T) {
+	now := curMilliseconds()
+	defer fakeClock(&now)()
+	s := newClockTestSnowflake(t, Config{ClockPolicy: ClockPolicySpareWorker, SpareWorkerIds: []int64{1000}})
+
+	_, _ = s.Gen("")
+	atomic.AddInt64(&now, -10)
+	id, err := s.Gen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := Decode(id, 0); info.WorkerId != 1000 {
+		t.Fatalf("expect spare workerId 1000, got %d", info.WorkerId)
+	}
+	// 再次回拨，主workerId仍未追上，备用workerId也已用过当前时间，无可用workerId
+	atomic.AddInt64(&now, -1)
+	if _, err = s.Gen(""); err == nil {
+		t.Fatal("expect error when no workerId available")
+	}
+	// 时钟恢复后继续使用备用workerId
+	atomic.AddInt64(&now, 20)
+	id, _ = s.Gen("")
+	if info := Decode(id, 0); info.WorkerId != 1000 {
+		t.Fatalf("expect workerId 1000, got %d", info.WorkerId)
+	}
+	if stats := s.ClockStats(); stats.Backwards != 2 || stats.WorkerSwitched != 1 || stats.Failed != 1 {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+
+	if err = New(Config{WorkerIdGetter: getworkerId, ClockPolicy: ClockPolicySpareWorker}).Init(); err == nil {
+		t.Fatal("expect error without spare workerId")
+	}
+	if err = New(Config{WorkerIdGetter: getworkerId, ClockPolicy: ClockPolicySpareWorker,
+		SpareWorkerIds: []int64{getworkerId()}}).Init(); err == nil {
+		t.Fatal("expect error when spare workerId is the primary workerId")
+	}
+	// 备用workerId可能已被 WorkerIdProvider 分配给其它节点
+	p := &fakeProvider{workerId: 1}
+	if err = New(Config{WorkerIdProvider: p, ClockPolicy: ClockPolicySpareWorker, SpareWorkerIds: []int64{2}}).Init(); err == nil {
+		t.Fatal("expect error with WorkerIdProvider")
+	}
+	if !p.released {
+		t.Fatal("expect workerId released after init failed")
+	}
+}
@@ -0,0 +1,242 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/entity"
+	"github.com/longyufei109/leaf-go/log"
+	"github.com/longyufei109/leaf-go/repo"
+	"github.com/longyufei109/leaf-go/service"
+	"github.com/longyufei109/leaf-go/service/snowflake"
+	"github.com/longyufei109/leaf-go/util"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultLeaseTTL int64 = 30
+
+// 数据库方式获取workerId，实现 snowflake.WorkerIdProvider
+// leaf_worker 表中每个workerId一行，记录持有者的 ip:port、租约到期时间和上报的时间戳
+// 优先使用本 ip:port 之前持有的workerId，其次接管租约已过期的，最后新增一行
+// 租约到期时间使用各节点的本地时间，节点间的时钟偏差需远小于租约时长
+// 被其它节点接管，或超过最近一次写入的租约到期时间仍未续约成功时处于不健康状态，snowflake不再生成id
+type WorkerIdProvider struct {
+	repo     repo.WorkerRepo
+	endpoint string
+	twepoch  int64
+	leaseTTL int64 // 毫秒
+
+	lock   sync.Mutex
+	worker *entity.Worker // 当前持有的
+
+	lost        atomic.Value // healthState，workerId已被其它节点接管，不再恢复
+	leaseExpire int64        // 最近一次成功写入的租约到期时间，原子访问，未持有时为0
+}
+
+type healthState struct {
+	err error
+}
+
+// twepoch <= 0 时使用 snowflake.DefaultTwepoch
+func NewWorkerIdProvider(r repo.WorkerRepo, conf *config.DBWorker, twepoch int64) *WorkerIdProvider {
+	if twepoch <= 0 {
+		twepoch = snowflake.DefaultTwepoch
+	}
+	leaseTTL := conf.LeaseTTL
+	if leaseTTL <= 0 {
+		leaseTTL = defaultLeaseTTL
+	}
+	return &WorkerIdProvider{
+		repo:     r,
+		endpoint: util.LocalIp() + ":" + conf.Port,
+		twepoch:  twepoch,
+		leaseTTL: leaseTTL * 1000,
+	}
+}
+
+// 数据库方式获取workerId的snowflake生成器，使用 config.Global.DB 中的数据库
+func NewSnowflakeDB(conf *config.DBWorker, sconf snowflake.Config) (service.IdGenerator, error) {
+	r, err := repo.NewWorkerRepo()
+	if err != nil {
+		return nil, err
+	}
+	sconf.WorkerIdProvider = NewWorkerIdProvider(r, conf, sconf.Twepoch)
+	return snowflake.New(sconf), nil
+}
+
+func (p *WorkerIdProvider) Acquire(maxWorkerId int64) (int64, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.worker != nil {
+		return -1, errors.New("workerId already acquired")
+	}
+	workers, err := p.repo.GetAllWorkers()
+	if err != nil {
+		return -1, err
+	}
+	now := curMilliseconds()
+
+	// 之前持有的，时钟回拨或twepoch不一致时直接失败，与zookeeper方式一致
+	for _, w := range workers {
+		if w.Endpoint != p.endpoint || w.WorkerId > maxWorkerId {
+			continue
+		}
+		if w.LastTimestamp > now {
+			return -1, &snowflake.ClockBackwardsError{Last: w.LastTimestamp, Now: now}
+		}
+		if w.Twepoch != p.twepoch {
+			return -1, fmt.Errorf("twepoch mismatch, registered:%d, configured:%d", w.Twepoch, p.twepoch)
+		}
+		if ok, err := p.take(w, now); err != nil || ok {
+			return p.result(err)
+		}
+	}
+
+	// 租约已过期的，跳过上报时间戳晚于当前时间的，避免生成重复id
+	used := make(map[int64]bool, len(workers))
+	for _, w := range workers {
+		used[w.WorkerId] = true
+		if w.Endpoint == p.endpoint || w.WorkerId > maxWorkerId || w.LeaseExpire >= now ||
+			w.LastTimestamp > now || w.Twepoch != p.twepoch {
+			continue
+		}
+		if ok, err := p.take(w, now); err != nil || ok {
+			if ok {
+				log.Print("[db] take over workerId %d from %s", w.WorkerId, w.Endpoint)
+			}
+			return p.result(err)
+		}
+	}
+
+	// 新增
+	for id := int64(0); id <= maxWorkerId; id++ {
+		if used[id] {
+			continue
+		}
+		w := entity.Worker{
+			WorkerId:      id,
+			Endpoint:      p.endpoint,
+			LeaseExpire:   now + p.leaseTTL,
+			LastTimestamp: now,
+			Twepoch:       p.twepoch,
+		}
+		ok, err := p.repo.InsertWorker(w)
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			p.hold(&w)
+			return p.result(nil)
+		}
+	}
+	return -1, fmt.Errorf("no free workerId in [0, %d]", maxWorkerId)
+}
+
+// 以乐观锁接管，其它节点同时接管时只有一个成功
+func (p *WorkerIdProvider) take(w entity.Worker, now int64) (bool, error) {
+	version := w.Version
+	w.Endpoint = p.endpoint
+	w.LeaseExpire = now + p.leaseTTL
+	w.Twepoch = p.twepoch
+	ok, err := p.repo.UpdateWorker(w, version)
+	if err != nil || !ok {
+		return false, err
+	}
+	w.Version++
+	p.hold(&w)
+	return true, nil
+}
+
+func (p *WorkerIdProvider) hold(w *entity.Worker) {
+	p.worker = w
+	p.lost.Store(healthState{})
+	atomic.StoreInt64(&p.leaseExpire, w.LeaseExpire)
+}
+
+func (p *WorkerIdProvider) result(err error) (int64, error) {
+	if err != nil {
+		return -1, err
+	}
+	log.Print("[db] endpoint %s acquire workerId:%d", p.endpoint, p.worker.WorkerId)
+	return p.worker.WorkerId, nil
+}
+
+// 延长租约
+func (p *WorkerIdProvider) Renew() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.worker == nil {
+		return errors.New("workerId not acquired")
+	}
+	return p.update(p.worker.LastTimestamp, curMilliseconds()+p.leaseTTL)
+}
+
+// 写入时间戳并延长租约，时间戳小于已上报的值时(系统时钟回拨)跳过
+func (p *WorkerIdProvider) ReportTimestamp(ts int64) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.worker != nil && ts < p.worker.LastTimestamp {
+		return nil
+	}
+	return p.update(ts, curMilliseconds()+p.leaseTTL)
+}
+
+// 租约立即过期，保留时间戳和持有者，本 ip:port 重启时仍优先使用该workerId
+func (p *WorkerIdProvider) Release() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.worker == nil {
+		return nil
+	}
+	err := p.update(p.worker.LastTimestamp, 0)
+	p.worker = nil
+	return err
+}
+
+func (p *WorkerIdProvider) update(ts int64, leaseExpire int64) error {
+	if p.worker == nil {
+		return errors.New("workerId not acquired")
+	}
+	w := *p.worker
+	w.LastTimestamp = ts
+	w.LeaseExpire = leaseExpire
+	ok, err := p.repo.UpdateWorker(w, w.Version)
+	if err != nil {
+		return err
+	}
+	if !ok { // 租约过期后被其它节点接管
+		err = fmt.Errorf("workerId %d is taken over by others", w.WorkerId)
+		p.lost.Store(healthState{err})
+		return err
+	}
+	w.Version++
+	p.worker = &w
+	atomic.StoreInt64(&p.leaseExpire, leaseExpire)
+	return nil
+}
+
+// 实现 snowflake.HealthChecker，不获取lock，避免数据库请求阻塞id生成
+func (p *WorkerIdProvider) Healthy() error {
+	if h, ok := p.lost.Load().(healthState); ok && h.err != nil {
+		return h.err
+	}
+	// 数据库不可用时无法续约，租约过期后可能被其它节点接管
+	if expire := atomic.LoadInt64(&p.leaseExpire); expire > 0 && curMilliseconds() >= expire {
+		return fmt.Errorf("lease expired at %d and not renewed", expire)
+	}
+	return nil
+}
+
+func (p *WorkerIdProvider) HealthDetails() map[string]interface{} {
+	return map[string]interface{}{
+		"worker_id_provider": "db",
+		"db_lease_expire":    atomic.LoadInt64(&p.leaseExpire),
+	}
+}
+
+// 变量形式便于测试时模拟时钟
+var curMilliseconds = func() int64 {
+	return time.Now().UnixNano() / 1e6
+}
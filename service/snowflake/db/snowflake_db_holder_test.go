@@ -0,0 +1,169 @@
+package db
+
+import (
+	"errors"
+	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/repo"
+	"github.com/longyufei109/leaf-go/service/snowflake"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestRepo(t *testing.T) repo.WorkerRepo {
+	config.Global.DB = config.DBConfig{
+		Type:            config.DB_Type_SQLite,
+		DataSource:      []string{"file:" + filepath.Join(t.TempDir(), "leaf.db")},
+		AutoCreateTable: true,
+	}
+	r, err := repo.NewWorkerRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func fakeClock(t *testing.T, ms *int64) {
+	origin := curMilliseconds
+	curMilliseconds = func() int64 { return *ms }
+	t.Cleanup(func() { curMilliseconds = origin })
+}
+
+func newTestProvider(r repo.WorkerRepo, port string) *WorkerIdProvider {
+	return NewWorkerIdProvider(r, &config.DBWorker{Port: port, LeaseTTL: 10}, 0)
+}
+
+func TestWorkerIdProvider_Acquire(t *testing.T) {
+	r := newTestRepo(t)
+
+	providers := make([]*WorkerIdProvider, 5)
+	ids := make([]int64, len(providers))
+	var wg sync.WaitGroup
+	for i := range providers {
+		providers[i] = newTestProvider(r, string(rune('0'+i)))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if ids[i], err = providers[i].Acquire(1023); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	seen := map[int64]bool{}
+	for _, id := range ids {
+		if id < 0 || id >= int64(len(ids)) || seen[id] {
+			t.Fatalf("unexpected workerIds:%v", ids)
+		}
+		seen[id] = true
+	}
+	if _, err := newTestProvider(r, "9").Acquire(int64(len(ids) - 1)); err == nil {
+		t.Fatal("expect no free workerId")
+	}
+
+	// 同一个 ip:port 重启后使用之前的workerId
+	if err := providers[2].Release(); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProvider(r, "2")
+	if id, err := p.Acquire(1023); err != nil || id != ids[2] {
+		t.Fatalf("expect workerId %d, got %d, err:%v", ids[2], id, err)
+	}
+}
+
+func TestWorkerIdProvider_TakeOver(t *testing.T) {
+	r := newTestRepo(t)
+	start := time.Now().UnixNano() / 1e6
+	now := start
+	fakeClock(t, &now)
+
+	old := newTestProvider(r, "8001")
+	id, err := old.Acquire(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 原节点的时钟较快，上报的时间戳晚于其它节点的当前时间
+	if err = old.ReportTimestamp(start + 20000); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProvider(r, "8002")
+	now = start + 5000 // 租约未过期
+	if _, err = p.Acquire(0); err == nil {
+		t.Fatal("expect no free workerId before lease expires")
+	}
+	now = start + 10001 // 租约已过期，但上报的时间戳晚于当前时间
+	if _, err = p.Acquire(0); err == nil {
+		t.Fatal("expect no free workerId before reported timestamp")
+	}
+	now = start + 20001
+	if next, err := p.Acquire(0); err != nil || next != id {
+		t.Fatalf("expect take over workerId %d, got %d, err:%v", id, next, err)
+	}
+	// 被接管后原节点续约失败
+	if err = old.Renew(); err == nil {
+		t.Fatal("expect renew failed after taken over")
+	}
+}
+
+func TestWorkerIdProvider_ClockBackwards(t *testing.T) {
+	r := newTestRepo(t)
+	now := time.Now().UnixNano() / 1e6
+	fakeClock(t, &now)
+
+	p := newTestProvider(r, "8001")
+	if _, err := p.Acquire(1023); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ReportTimestamp(now + 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ReportTimestamp(now + 500); err != nil { // 小于已上报的值时跳过
+		t.Fatal(err)
+	}
+	if err := p.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	var clockErr *snowflake.ClockBackwardsError
+	if _, err := newTestProvider(r, "8001").Acquire(1023); !errors.As(err, &clockErr) || clockErr.Last != now+1000 {
+		t.Fatalf("expect clock backwards error, got %v", err)
+	}
+}
+
+func TestWorkerIdProvider_Healthy(t *testing.T) {
+	r := newTestRepo(t)
+	start := time.Now().UnixNano() / 1e6
+	now := start
+	fakeClock(t, &now)
+
+	old := newTestProvider(r, "8001")
+	g := snowflake.New(snowflake.Config{WorkerIdProvider: old, RenewInterval: time.Hour})
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Shutdown()
+	now = start + 5000
+	if _, err := g.Gen(""); err != nil {
+		t.Fatal(err)
+	}
+	// 未能续约，租约过期后不再生成
+	now = start + 10001
+	if _, err := g.Gen(""); err == nil {
+		t.Fatal("expect Gen failed after lease expired")
+	}
+
+	// 被其它节点接管后续约失败，不再恢复
+	if _, err := newTestProvider(r, "8002").Acquire(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Renew(); err == nil {
+		t.Fatal("expect renew failed after taken over")
+	}
+	now = start
+	if _, err := g.Gen(""); err == nil {
+		t.Fatal("expect Gen failed after taken over")
+	}
+}
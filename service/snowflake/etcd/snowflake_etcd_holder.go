@@ -0,0 +1,341 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/log"
+	"github.com/longyufei109/leaf-go/service"
+	"github.com/longyufei109/leaf-go/service/snowflake"
+	"github.com/longyufei109/leaf-go/util"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultLeaseTTL int64 = 10
+	dialTimeout           = 5 * time.Second
+	requestTimeout        = 5 * time.Second
+)
+
+type endpoint struct {
+	Ip        string `json:"ip"`
+	Port      string `json:"port"`
+	Timestamp int64  `json:"timestamp"`
+	Twepoch   int64  `json:"twepoch"`
+}
+
+// etcd方式获取workerId，实现 snowflake.WorkerIdProvider
+// /snowflake/{leafName}/workers/{workerId} 绑定租约，租约过期后workerId可被其它节点获取
+// /snowflake/{leafName}/timestamps/{workerId} 为永久key，记录该workerId上报的时间戳，获取workerId时检查时钟回拨
+// workerId被其它节点获取，或超过租约时长未能续约(可能已过期)时处于不健康状态，snowflake不再生成id
+type WorkerIdProvider struct {
+	conf    config.Etcd
+	twepoch int64
+	ip      string
+
+	workersPath    string
+	timestampsPath string
+
+	lock     sync.Mutex
+	client   *clientv3.Client
+	lease    clientv3.LeaseID
+	workerId int64
+
+	lost      atomic.Value // healthState，workerId已被其它节点获取，不再恢复
+	keepAlive atomic.Value // time.Time，最近一次续约成功前发出请求的时间
+}
+
+type healthState struct {
+	err error
+}
+
+// twepoch <= 0 时使用 snowflake.DefaultTwepoch
+func NewWorkerIdProvider(econf *config.Etcd, twepoch int64) *WorkerIdProvider {
+	if twepoch <= 0 {
+		twepoch = snowflake.DefaultTwepoch
+	}
+	conf := *econf
+	if conf.LeaseTTL <= 0 {
+		conf.LeaseTTL = defaultLeaseTTL
+	}
+	prefix := "/snowflake/" + conf.LeafName
+	return &WorkerIdProvider{
+		conf:           conf,
+		twepoch:        twepoch,
+		ip:             util.LocalIp(),
+		workersPath:    prefix + "/workers/",
+		timestampsPath: prefix + "/timestamps/",
+		workerId:       -1,
+	}
+}
+
+// etcd方式获取workerId的snowflake生成器
+func NewSnowflakeEtcd(econf *config.Etcd, conf snowflake.Config) service.IdGenerator {
+	conf.WorkerIdProvider = NewWorkerIdProvider(econf, conf.Twepoch)
+	return snowflake.New(conf)
+}
+
+// 获取最小的可用workerId：未被占用，且上次上报的时间戳不晚于当前时间、twepoch一致
+// 通过事务保证并发获取时不会重复
+func (p *WorkerIdProvider) Acquire(maxWorkerId int64) (workerId int64, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.client != nil {
+		return -1, errors.New("workerId already acquired")
+	}
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   p.conf.Endpoints,
+		Username:    p.conf.User,
+		Password:    p.conf.Pwd,
+		DialTimeout: dialTimeout,
+	})
+	if err != nil {
+		return -1, err
+	}
+	defer func() {
+		if err != nil {
+			_ = client.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	lease, err := client.Grant(ctx, p.conf.LeaseTTL)
+	if err != nil {
+		return -1, fmt.Errorf("grant lease failed. err:%v", err)
+	}
+	defer func() {
+		if err != nil {
+			_, _ = client.Revoke(context.Background(), lease.ID)
+		}
+	}()
+
+	used, err := p.usedWorkerIds(ctx, client)
+	if err != nil {
+		return -1, err
+	}
+	timestamps, err := p.reportedTimestamps(ctx, client)
+	if err != nil {
+		return -1, err
+	}
+	now := time.Now()
+	for id := int64(0); id <= maxWorkerId; id++ {
+		if used[id] {
+			continue
+		}
+		if e, ok := timestamps[id]; ok && (e.Timestamp > now.UnixNano()/1e6 || e.Twepoch != p.twepoch) {
+			continue // 使用该workerId可能生成重复id
+		}
+		ok, err := p.claim(ctx, client, lease.ID, id, now.UnixNano()/1e6, true)
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			p.client = client
+			p.lease = lease.ID
+			p.workerId = id
+			p.keepAlive.Store(now)
+			log.Print("[etcd] acquire workerId:%d, lease:%x", id, lease.ID)
+			return id, nil
+		}
+	}
+	return -1, fmt.Errorf("no free workerId in [0, %d]", maxWorkerId)
+}
+
+func (p *WorkerIdProvider) usedWorkerIds(ctx context.Context, client *clientv3.Client) (map[int64]bool, error) {
+	resp, err := client.Get(ctx, p.workersPath, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[int64]bool, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		if id, err := strconv.ParseInt(strings.TrimPrefix(string(kv.Key), p.workersPath), 10, 64); err == nil {
+			used[id] = true
+		}
+	}
+	return used, nil
+}
+
+func (p *WorkerIdProvider) reportedTimestamps(ctx context.Context, client *clientv3.Client) (map[int64]*endpoint, error) {
+	resp, err := client.Get(ctx, p.timestampsPath, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	timestamps := make(map[int64]*endpoint, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		id, err := strconv.ParseInt(strings.TrimPrefix(string(kv.Key), p.timestampsPath), 10, 64)
+		if err != nil {
+			continue
+		}
+		e := &endpoint{}
+		if err = json.Unmarshal(kv.Value, e); err != nil {
+			return nil, fmt.Errorf("invalid value of %s. err:%v", kv.Key, err)
+		}
+		timestamps[id] = e
+	}
+	return timestamps, nil
+}
+
+// workerId未被占用时绑定到租约，首次获取时同时记录时间戳
+func (p *WorkerIdProvider) claim(ctx context.Context, client *clientv3.Client, lease clientv3.LeaseID,
+	workerId int64, ts int64, first bool) (bool, error) {
+	data, err := p.buildData(ts)
+	if err != nil {
+		return false, err
+	}
+	key := p.workerKey(workerId)
+	ops := []clientv3.Op{clientv3.OpPut(key, data, clientv3.WithLease(lease))}
+	if first {
+		ops = append(ops, clientv3.OpPut(p.timestampKey(workerId), data))
+	}
+	resp, err := client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(ops...).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// 续约，租约已过期时尝试用新租约重新获取同一个workerId
+func (p *WorkerIdProvider) Renew() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.client == nil {
+		return errors.New("workerId not acquired")
+	}
+	if err := p.Healthy(); errors.Is(err, errWorkerIdLost) {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	start := time.Now()
+	_, err := p.client.KeepAliveOnce(ctx, p.lease)
+	if err == nil {
+		p.keepAlive.Store(start)
+	}
+	if !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		return err
+	}
+
+	lease, err := p.client.Grant(ctx, p.conf.LeaseTTL)
+	if err != nil {
+		return fmt.Errorf("grant lease failed. err:%v", err)
+	}
+	start = time.Now()
+	ok, err := p.claim(ctx, p.client, lease.ID, p.workerId, start.UnixNano()/1e6, false)
+	if err != nil || !ok {
+		_, _ = p.client.Revoke(ctx, lease.ID)
+		if err == nil { // 已被其它节点获取
+			err = fmt.Errorf("%w, workerId:%d", errWorkerIdLost, p.workerId)
+			p.lost.Store(healthState{err})
+			return err
+		}
+		return fmt.Errorf("lease expired and reacquire workerId %d failed, err:%v", p.workerId, err)
+	}
+	log.Print("[etcd] lease expired, reacquire workerId:%d, lease:%x", p.workerId, lease.ID)
+	p.lease = lease.ID
+	p.keepAlive.Store(start)
+	return nil
+}
+
+// 仍持有workerId时写入时间戳
+func (p *WorkerIdProvider) ReportTimestamp(ts int64) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.client == nil {
+		return errors.New("workerId not acquired")
+	}
+	data, err := p.buildData(ts)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	key := p.workerKey(p.workerId)
+	resp, err := p.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.LeaseValue(key), "=", p.lease)).
+		Then(clientv3.OpPut(p.timestampKey(p.workerId), data)).
+		Else(clientv3.OpGet(key, clientv3.WithKeysOnly())).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		if len(resp.Responses) > 0 && len(resp.Responses[0].GetResponseRange().Kvs) > 0 {
+			// 被其它租约持有；不存在时租约已过期，由 Renew 重新获取
+			err = fmt.Errorf("%w, workerId:%d", errWorkerIdLost, p.workerId)
+			p.lost.Store(healthState{err})
+			return err
+		}
+		return fmt.Errorf("workerId %d is not held by lease %x", p.workerId, p.lease)
+	}
+	return nil
+}
+
+var errWorkerIdLost = errors.New("workerId is held by others")
+
+// 实现 snowflake.HealthChecker，不获取lock，避免续约的网络请求阻塞id生成
+func (p *WorkerIdProvider) Healthy() error {
+	if h, ok := p.lost.Load().(healthState); ok {
+		return h.err
+	}
+	last, ok := p.keepAlive.Load().(time.Time)
+	if !ok { // 尚未获取workerId
+		return nil
+	}
+	// 网络分区时收不到 ErrLeaseNotFound，租约可能已过期并被其它节点获取
+	if since := time.Since(last); since >= time.Duration(p.conf.LeaseTTL)*time.Second {
+		return fmt.Errorf("lease not renewed for %v, may have expired", since.Truncate(time.Millisecond))
+	}
+	return nil
+}
+
+func (p *WorkerIdProvider) HealthDetails() map[string]interface{} {
+	details := map[string]interface{}{
+		"worker_id_provider": "etcd",
+	}
+	if last, ok := p.keepAlive.Load().(time.Time); ok {
+		details["etcd_last_keep_alive"] = last
+	}
+	return details
+}
+
+// 撤销租约并关闭连接，时间戳保留
+func (p *WorkerIdProvider) Release() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.client == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	_, err := p.client.Revoke(ctx, p.lease)
+	if closeErr := p.client.Close(); err == nil {
+		err = closeErr
+	}
+	p.client = nil
+	return err
+}
+
+func (p *WorkerIdProvider) workerKey(workerId int64) string {
+	return p.workersPath + strconv.FormatInt(workerId, 10)
+}
+
+func (p *WorkerIdProvider) timestampKey(workerId int64) string {
+	return p.timestampsPath + strconv.FormatInt(workerId, 10)
+}
+
+func (p *WorkerIdProvider) buildData(ts int64) (string, error) {
+	data, err := json.Marshal(&endpoint{p.ip, p.conf.Port, ts, p.twepoch})
+	return string(data), err
+}
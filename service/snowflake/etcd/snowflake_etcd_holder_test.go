@@ -0,0 +1,242 @@
+package etcd
+
+import (
+	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/service/snowflake"
+	"go.etcd.io/etcd/server/v3/embed"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func freeUrl(t *testing.T) url.URL {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return url.URL{Scheme: "http", Host: ln.Addr().String()}
+}
+
+// 启动嵌入的单节点etcd，返回client地址
+func startEtcd(t *testing.T) string {
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientUrl, peerUrl := freeUrl(t), freeUrl(t)
+	cfg.ListenClientUrls = []url.URL{clientUrl}
+	cfg.AdvertiseClientUrls = []url.URL{clientUrl}
+	cfg.ListenPeerUrls = []url.URL{peerUrl}
+	cfg.AdvertisePeerUrls = []url.URL{peerUrl}
+	cfg.InitialCluster = cfg.Name + "=" + peerUrl.String()
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd not ready")
+	}
+	return clientUrl.String()
+}
+
+func newTestProvider(endpoint string) *WorkerIdProvider {
+	return NewWorkerIdProvider(&config.Etcd{LeafName: "test", Endpoints: []string{endpoint}, LeaseTTL: 2}, 0)
+}
+
+func TestWorkerIdProvider_Acquire(t *testing.T) {
+	endpoint := startEtcd(t)
+
+	// 并发获取不会重复，且为最小的可用id
+	providers := make([]*WorkerIdProvider, 8)
+	ids := make([]int64, len(providers))
+	var wg sync.WaitGroup
+	for i := range providers {
+		providers[i] = newTestProvider(endpoint)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if ids[i], err = providers[i].Acquire(1023); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	seen := map[int64]bool{}
+	for _, id := range ids {
+		if id < 0 || id >= int64(len(ids)) || seen[id] {
+			t.Fatalf("unexpected workerIds:%v", ids)
+		}
+		seen[id] = true
+	}
+
+	// 已占满
+	if _, err := newTestProvider(endpoint).Acquire(int64(len(ids) - 1)); err == nil {
+		t.Fatal("expect no free workerId")
+	}
+
+	// 释放后可被重新获取
+	released := providers[3]
+	if err := released.Release(); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProvider(endpoint)
+	if id, err := p.Acquire(1023); err != nil || id != ids[3] {
+		t.Fatalf("expect workerId %d, got %d, err:%v", ids[3], id, err)
+	}
+	providers[3] = p
+	for _, p := range providers {
+		if err := p.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWorkerIdProvider_ReportTimestamp(t *testing.T) {
+	endpoint := startEtcd(t)
+
+	p := newTestProvider(endpoint)
+	id, err := p.Acquire(1023)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 上报一个未来的时间戳，模拟时钟回拨后重启
+	if err = p.ReportTimestamp(time.Now().UnixNano()/1e6 + 60000); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	p = newTestProvider(endpoint)
+	next, err := p.Acquire(1023)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	if next == id {
+		t.Fatalf("workerId %d with future timestamp should be skipped", id)
+	}
+}
+
+func TestWorkerIdProvider_Renew(t *testing.T) {
+	endpoint := startEtcd(t)
+
+	p := newTestProvider(endpoint)
+	id, err := p.Acquire(1023)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	for i := 0; i < 3; i++ { // 超过租约时长后仍持有
+		time.Sleep(time.Second)
+		if err = p.Renew(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if other, err := newTestProvider(endpoint).Acquire(0); err == nil {
+		t.Fatalf("workerId %d should be held, got %d", id, other)
+	}
+
+	// 租约过期后用新租约重新获取
+	if _, err = p.client.Revoke(p.client.Ctx(), p.lease); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Renew(); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.ReportTimestamp(time.Now().UnixNano() / 1e6); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewSnowflakeEtcd(t *testing.T) {
+	endpoint := startEtcd(t)
+
+	g := NewSnowflakeEtcd(&config.Etcd{LeafName: "test", Endpoints: []string{endpoint}},
+		snowflake.Config{RenewInterval: 100 * time.Millisecond})
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	id, err := g.Gen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	g.Shutdown()
+	if info := snowflake.Decode(id, 0); info.WorkerId != 0 {
+		t.Fatalf("expect workerId 0, got %d", info.WorkerId)
+	}
+}
+
+func TestNewSnowflakeEtcd_WorkerIdLost(t *testing.T) {
+	endpoint := startEtcd(t)
+
+	p := newTestProvider(endpoint)
+	g := snowflake.New(snowflake.Config{WorkerIdProvider: p, RenewInterval: 100 * time.Millisecond})
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Shutdown()
+	if _, err := g.Gen(""); err != nil {
+		t.Fatal(err)
+	}
+
+	// 租约过期后被其它节点获取
+	p.lock.Lock()
+	_, err := p.client.Revoke(p.client.Ctx(), p.lease)
+	p.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newTestProvider(endpoint)
+	if id, err := other.Acquire(0); err != nil || id != 0 {
+		t.Fatalf("expect workerId 0, got %d, err:%v", id, err)
+	}
+	defer other.Release()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if _, err = g.Gen(""); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expect Gen failed after workerId lost")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	time.Sleep(300 * time.Millisecond) // 之后不再恢复
+	if _, err = g.Gen(""); err == nil {
+		t.Fatal("expect Gen failed after workerId lost")
+	}
+}
+
+func TestWorkerIdProvider_Healthy(t *testing.T) {
+	endpoint := startEtcd(t)
+
+	p := newTestProvider(endpoint)
+	if _, err := p.Acquire(1023); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	if err := p.Healthy(); err != nil {
+		t.Fatal(err)
+	}
+	// 网络分区时续约失败，超过租约时长后不健康
+	p.keepAlive.Store(time.Now().Add(-3 * time.Second))
+	if err := p.Healthy(); err == nil {
+		t.Fatal("expect unhealthy when lease not renewed")
+	}
+	if err := p.Renew(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Healthy(); err != nil {
+		t.Fatal(err)
+	}
+}
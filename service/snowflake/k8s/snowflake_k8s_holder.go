@@ -0,0 +1,135 @@
+package k8s
+
+import (
+	"fmt"
+	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/service"
+	"github.com/longyufei109/leaf-go/service/snowflake"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultEnv  = "POD_NAME"
+	defaultFile = "/etc/podinfo/labels"
+	indexLabel  = "apps.kubernetes.io/pod-index"
+)
+
+// 由 StatefulSet 中pod的序号得到workerId，实现 snowflake.WorkerIdProvider
+// workerId = 序号 + Offset，多个数据中心(集群)共用workerId范围时，各自配置不同的Offset
+// 序号是稳定的，pod重建后不变，所以无需续约和上报时间戳，时钟回拨需配合 snowflake.timestampFile 处理
+type WorkerIdProvider struct {
+	conf config.K8s
+}
+
+func NewWorkerIdProvider(conf *config.K8s) *WorkerIdProvider {
+	return &WorkerIdProvider{conf: *conf}
+}
+
+func NewSnowflakeK8s(conf *config.K8s, sconf snowflake.Config) service.IdGenerator {
+	sconf.WorkerIdProvider = NewWorkerIdProvider(conf)
+	return snowflake.New(sconf)
+}
+
+func (p *WorkerIdProvider) Acquire(maxWorkerId int64) (int64, error) {
+	source, value, err := p.read()
+	if err != nil {
+		return -1, err
+	}
+	ordinal, err := parseOrdinal(value)
+	if err != nil {
+		return -1, fmt.Errorf("parse ordinal from %s failed. err:%v", source, err)
+	}
+	workerId := ordinal + p.conf.Offset
+	if workerId < 0 || workerId > maxWorkerId {
+		return -1, fmt.Errorf("workerId %d (ordinal %d from %s + offset %d) should be in [0, %d]",
+			workerId, ordinal, source, p.conf.Offset, maxWorkerId)
+	}
+	return workerId, nil
+}
+
+func (p *WorkerIdProvider) Renew() error {
+	return nil
+}
+
+func (p *WorkerIdProvider) Release() error {
+	return nil
+}
+
+func (p *WorkerIdProvider) ReportTimestamp(_ int64) error {
+	return nil
+}
+
+// 返回来源的描述和内容
+func (p *WorkerIdProvider) read() (string, string, error) {
+	switch p.conf.Source {
+	case 0, config.K8s_Source_Hostname:
+		name, err := hostname()
+		return "hostname", name, err
+	case config.K8s_Source_Env:
+		env := p.conf.Env
+		if env == "" {
+			env = defaultEnv
+		}
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			return "", "", fmt.Errorf("env %s not set", env)
+		}
+		return "env " + env, value, nil
+	case config.K8s_Source_File:
+		file := p.conf.File
+		if file == "" {
+			file = defaultFile
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", "", err
+		}
+		return "file " + file, string(data), nil
+	}
+	return "", "", fmt.Errorf("not support k8s source:%d", p.conf.Source)
+}
+
+// 支持以下格式:
+// 纯数字: 3
+// pod名称: leaf-3
+// downward API 的labels文件: 包含 apps.kubernetes.io/pod-index="3" 时使用该值，否则使用 statefulset.kubernetes.io/pod-name
+func parseOrdinal(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "=") {
+		labels := parseLabels(value)
+		if index, ok := labels[indexLabel]; ok {
+			value = index
+		} else if name, ok := labels["statefulset.kubernetes.io/pod-name"]; ok {
+			value = name
+		} else {
+			return -1, fmt.Errorf("neither %s nor statefulset.kubernetes.io/pod-name found", indexLabel)
+		}
+	}
+	s := value[strings.LastIndex(value, "-")+1:]
+	ordinal, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ordinal < 0 {
+		return -1, fmt.Errorf("no ordinal in %q", value)
+	}
+	return ordinal, nil
+}
+
+// 每行一个 key="value"
+func parseLabels(data string) map[string]string {
+	labels := map[string]string{}
+	for _, line := range strings.Split(data, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if v, err := strconv.Unquote(kv[1]); err == nil {
+			kv[1] = v
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return labels
+}
+
+// 变量形式便于测试
+var hostname = os.Hostname
@@ -0,0 +1,84 @@
+package k8s
+
+import (
+	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/service/snowflake"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseOrdinal(t *testing.T) {
+	cases := []struct {
+		value   string
+		ordinal int64
+		ok      bool
+	}{
+		{"3", 3, true},
+		{"leaf-3\n", 3, true},
+		{"my-leaf-12", 12, true},
+		{"app=\"leaf\"\napps.kubernetes.io/pod-index=\"5\"\nstatefulset.kubernetes.io/pod-name=\"leaf-7\"\n", 5, true},
+		{"app=\"leaf\"\nstatefulset.kubernetes.io/pod-name=\"leaf-7\"\n", 7, true},
+		{"app=\"leaf\"\n", 0, false},
+		{"leaf", 0, false},
+		{"leaf-", 0, false},
+	}
+	for _, c := range cases {
+		ordinal, err := parseOrdinal(c.value)
+		if (err == nil) != c.ok || (c.ok && ordinal != c.ordinal) {
+			t.Fatalf("value:%q, expect %d ok:%v, got %d err:%v", c.value, c.ordinal, c.ok, ordinal, err)
+		}
+	}
+}
+
+func TestWorkerIdProvider_Acquire(t *testing.T) {
+	origin := hostname
+	hostname = func() (string, error) { return "leaf-3", nil }
+	defer func() { hostname = origin }()
+
+	if id, err := NewWorkerIdProvider(&config.K8s{Offset: 100}).Acquire(1023); err != nil || id != 103 {
+		t.Fatalf("expect 103, got %d, err:%v", id, err)
+	}
+	if _, err := NewWorkerIdProvider(&config.K8s{Offset: 1021}).Acquire(1023); err == nil {
+		t.Fatal("expect out of range error")
+	}
+
+	t.Setenv("LEAF_POD", "leaf-8")
+	if id, err := NewWorkerIdProvider(&config.K8s{Source: config.K8s_Source_Env, Env: "LEAF_POD"}).Acquire(1023); err != nil || id != 8 {
+		t.Fatalf("expect 8, got %d, err:%v", id, err)
+	}
+	if _, err := NewWorkerIdProvider(&config.K8s{Source: config.K8s_Source_Env, Env: "LEAF_NOT_SET"}).Acquire(1023); err == nil {
+		t.Fatal("expect env not set error")
+	}
+
+	file := filepath.Join(t.TempDir(), "labels")
+	if err := os.WriteFile(file, []byte("apps.kubernetes.io/pod-index=\"2\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := NewWorkerIdProvider(&config.K8s{Source: config.K8s_Source_File, File: file}).Acquire(1023); err != nil || id != 2 {
+		t.Fatalf("expect 2, got %d, err:%v", id, err)
+	}
+}
+
+func TestNewSnowflakeK8s(t *testing.T) {
+	t.Setenv("POD_NAME", "leaf-40")
+	conf := &config.K8s{Source: config.K8s_Source_Env}
+	// 5 bits workerId 时超出范围，启动失败而不是panic
+	g := NewSnowflakeK8s(conf, snowflake.Config{Layout: snowflake.Layout{DatacenterIdBits: 5, WorkerIdBits: 5, SequenceBits: 12}})
+	if err := g.Init(); err == nil {
+		t.Fatal("expect out of range error")
+	}
+
+	g = NewSnowflakeK8s(conf, snowflake.Config{})
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Shutdown()
+	id, err := g.Gen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := snowflake.Decode(id, 0); info.WorkerId != 40 {
+		t.Fatalf("expect workerId 40, got %d", info.WorkerId)
+	}
+}
@@ -0,0 +1,92 @@
+package snowflake
+
+import (
+	"fmt"
+	"github.com/longyufei109/leaf-go/entity"
+	"github.com/longyufei109/leaf-go/log"
+	"time"
+)
+
+// id的位分配，从高位到低位依次为:
+// 1bit not used | timestamp(ms) | datacenterId | workerId | sequence
+// timestamp 占用剩余的位数，即 63 - DatacenterIdBits - WorkerIdBits - SequenceBits
+type Layout struct {
+	DatacenterIdBits int64 // 可以为0，即不区分数据中心
+	WorkerIdBits     int64
+	SequenceBits     int64
+}
+
+// 默认 41 bits timestamp | 10 bits workerId | 12 bits sequence
+// 兼容已有的id，不做2100年溢出检查(以默认twepoch计算，时间戳在2090年溢出)，溢出早于2100年时只记录日志
+var DefaultLayout = Layout{WorkerIdBits: 10, SequenceBits: 12}
+
+// 自定义布局需保证在此之前时间戳不会溢出
+var timestampHorizon = time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func (l Layout) TimestampBits() int64 {
+	return 63 - l.DatacenterIdBits - l.WorkerIdBits - l.SequenceBits
+}
+
+func (l Layout) MaxDatacenterId() int64 {
+	return 1<<uint64(l.DatacenterIdBits) - 1
+}
+
+func (l Layout) MaxWorkerId() int64 {
+	return 1<<uint64(l.WorkerIdBits) - 1
+}
+
+func (l Layout) SequenceMask() int64 {
+	return 1<<uint64(l.SequenceBits) - 1
+}
+
+func (l Layout) MaxTimestamp() int64 {
+	return 1<<uint64(l.TimestampBits()) - 1
+}
+
+func (l Layout) workerIdShift() uint64 {
+	return uint64(l.SequenceBits)
+}
+
+func (l Layout) datacenterIdShift() uint64 {
+	return uint64(l.WorkerIdBits + l.SequenceBits)
+}
+
+func (l Layout) timestampShift() uint64 {
+	return uint64(l.DatacenterIdBits + l.WorkerIdBits + l.SequenceBits)
+}
+
+// 检查各部分位数，以及从twepoch开始到2100年之前时间戳是否会溢出
+func (l Layout) Validate(twepoch int64) error {
+	if l.DatacenterIdBits < 0 || l.WorkerIdBits <= 0 || l.SequenceBits <= 0 {
+		return fmt.Errorf("invalid layout %+v, workerIdBits and sequenceBits must be positive", l)
+	}
+	if l.TimestampBits() <= 0 {
+		return fmt.Errorf("invalid layout %+v, more than 63 bits", l)
+	}
+	if twepoch+l.MaxTimestamp() < timestampHorizon.UnixNano()/1e6 {
+		overflow := time.Unix(0, (twepoch+l.MaxTimestamp())*1e6)
+		if l == DefaultLayout {
+			log.Print("[snowflake] default layout timestamp overflows at %s, before %d", overflow.Format("2006-01-02"), timestampHorizon.Year())
+			return nil
+		}
+		return fmt.Errorf("invalid layout %+v, timestamp(%d bits) overflows at %s, before %d",
+			l, l.TimestampBits(), overflow.Format("2006-01-02"), timestampHorizon.Year())
+	}
+	return nil
+}
+
+// 按当前布局将id拆分为时间戳、datacenterId、workerId和序列号
+func (l Layout) Decode(id int64, twepoch int64) entity.IdInfo {
+	if twepoch <= 0 {
+		twepoch = DefaultTwepoch
+	}
+	ts := (id >> l.timestampShift()) + twepoch
+	return entity.IdInfo{
+		Id:           id,
+		Timestamp:    ts,
+		Time:         time.Unix(0, ts*1e6),
+		DatacenterId: (id >> l.datacenterIdShift()) & l.MaxDatacenterId(),
+		WorkerId:     (id >> l.workerIdShift()) & l.MaxWorkerId(),
+		Sequence:     id & l.SequenceMask(),
+	}
+}
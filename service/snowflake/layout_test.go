@@ -0,0 +1,82 @@
+package snowflake
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLayout_Validate(t *testing.T) {
+	cases := []struct {
+		layout Layout
+		ok     bool
+	}{
+		{DefaultLayout, true},
+		{Layout{DatacenterIdBits: 5, WorkerIdBits: 5, SequenceBits: 11}, true},
+		{Layout{WorkerIdBits: 13, SequenceBits: 8}, true},
+		{Layout{DatacenterIdBits: 5, WorkerIdBits: 5, SequenceBits: 12}, false}, // 41 bits timestamp，2090年溢出
+		{Layout{WorkerIdBits: 12, SequenceBits: 12}, false},
+		{Layout{WorkerIdBits: 40, SequenceBits: 30}, false}, // 超过63位
+		{Layout{WorkerIdBits: 10, SequenceBits: 0}, false},
+		{Layout{DatacenterIdBits: -1, WorkerIdBits: 10, SequenceBits: 10}, false},
+	}
+	for _, c := range cases {
+		err := c.layout.Validate(DefaultTwepoch)
+		if (err == nil) != c.ok {
+			t.Errorf("layout:%+v, expect ok:%v, err:%v", c.layout, c.ok, err)
+		}
+	}
+
+	// 默认布局兼容任意twepoch，自定义布局按twepoch检查
+	custom := Layout{DatacenterIdBits: 5, WorkerIdBits: 5, SequenceBits: 12}
+	boundary := timestampHorizon.UnixNano()/1e6 - custom.MaxTimestamp()
+	for _, c := range []struct {
+		layout  Layout
+		twepoch int64
+		ok      bool
+	}{
+		{DefaultLayout, DefaultTwepoch, true},
+		{DefaultLayout, time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano() / 1e6, true},
+		{DefaultLayout, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano() / 1e6, true},
+		{custom, boundary - 1, false},
+		{custom, boundary, true},
+	} {
+		if err := c.layout.Validate(c.twepoch); (err == nil) != c.ok {
+			t.Errorf("layout:%+v, twepoch:%d, expect ok:%v, err:%v", c.layout, c.twepoch, c.ok, err)
+		}
+	}
+}
+
+func TestSnowflake_CustomLayout(t *testing.T) {
+	conf := Config{
+		WorkerIdGetter: func() int64 { return 31 },
+		Layout:         Layout{DatacenterIdBits: 5, WorkerIdBits: 5, SequenceBits: 11},
+		DatacenterId:   17,
+	}
+	g := New(conf)
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := g.GenBatch("", 3000) // 超过单毫秒的序列号数量
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range ids {
+		info, _ := g.(*snowflake).Decode(id)
+		if info.DatacenterId != 17 || info.WorkerId != 31 || info.Sequence > 2047 {
+			t.Fatalf("unexpected info:%+v", info)
+		}
+		if i > 0 && id <= ids[i-1] {
+			t.Fatalf("ids not increasing, %d <= %d", id, ids[i-1])
+		}
+	}
+
+	conf.WorkerIdGetter = func() int64 { return 32 }
+	if err = New(conf).Init(); err == nil {
+		t.Fatal("expect error for workerId out of range")
+	}
+	conf.WorkerIdGetter = func() int64 { return 1 }
+	conf.DatacenterId = 32
+	if err = New(conf).Init(); err == nil {
+		t.Fatal("expect error for datacenterId out of range")
+	}
+}
@@ -0,0 +1,99 @@
+package snowflake
+
+import (
+	"fmt"
+	"github.com/longyufei109/leaf-go/metrics"
+	randv2 "math/rand/v2"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// 无锁实现: 将 (timestamp - twepoch) 和 sequence 按id的布局打包在一个int64中，通过CAS推进
+// 生成id时再拼上固定的 datacenterId 和 workerId，即 id = state | nodeBits
+// 不支持 ClockPolicySpareWorker
+
+func (s *snowflake) initLockFree() error {
+	if s.conf.ClockPolicy == ClockPolicySpareWorker {
+		return fmt.Errorf("clock policy %d not supported by lock-free snowflake", s.conf.ClockPolicy)
+	}
+	s.nodeBits = (s.conf.DatacenterId << s.datacenterIdShift) | (s.workerId << s.workerIdShift)
+	return nil
+}
+
+// 启动时已使用过的最大时间戳为ts，将该毫秒的序列号置为用尽，之后只能使用更大的时间戳
+func (s *snowflake) resetLockFreeState(ts int64) {
+	atomic.StoreInt64(&s.state, ((ts-s.conf.Twepoch)<<s.timestampShift)|s.sequenceMask)
+}
+
+func (s *snowflake) lockFreeTimestamp() int64 {
+	state := atomic.LoadInt64(&s.state)
+	if state == 0 {
+		return 0
+	}
+	return (state >> s.timestampShift) + s.conf.Twepoch
+}
+
+func (s *snowflake) nextIdLockFree() (int64, error) {
+	// 与加锁实现一致，每次调用只计数一次，等待后重试或CAS失败重试时不重复计数
+	backwards, waited, borrowed, exhausted := false, false, false, false
+	for {
+		old := atomic.LoadInt64(&s.state)
+		last := old >> s.timestampShift // 相对于twepoch
+		seq := old & s.sequenceMask
+		now := curMilliseconds() - s.conf.Twepoch
+
+		var next int64
+		if now > last { // 全新的时间戳
+			if now > s.maxTimestamp {
+				return -1, fmt.Errorf("timestamp overflow, layout:%+v", s.layout)
+			}
+			// math/rand 的全局函数有锁，这里使用无锁的 math/rand/v2
+			next = (now << s.timestampShift) | randv2.Int64N(s.randomSequence)
+		} else {
+			if now < last { // 时钟回拨
+				if !backwards {
+					backwards = true
+					s.stats.inc(clockBackwards)
+				}
+				offset := last - now
+				switch {
+				case s.conf.ClockPolicy == ClockPolicyWait && offset <= s.conf.MaxBackwardMs && !waited:
+					time.Sleep(time.Duration(offset) * time.Millisecond)
+					waited = true
+					continue
+				case s.conf.ClockPolicy == ClockPolicyBorrow && offset <= s.conf.MaxBackwardMs:
+					// 继续使用last，序列号用尽时借用last+1
+					if seq == s.sequenceMask && last+1-now > s.conf.MaxBackwardMs {
+						s.stats.inc(clockFailed)
+						return -1, &ClockBackwardsError{Last: last + s.conf.Twepoch, Now: now + s.conf.Twepoch}
+					}
+					borrowed = true
+				default:
+					s.stats.inc(clockFailed)
+					return -1, &ClockBackwardsError{Last: last + s.conf.Twepoch, Now: now + s.conf.Twepoch}
+				}
+			}
+			if seq < s.sequenceMask {
+				next = old + 1
+			} else if now < last { // 借用未来的时间戳
+				next = ((last + 1) << s.timestampShift) | randv2.Int64N(s.randomSequence)
+			} else { // 当前这1毫秒内的序列号用尽了，等待下一毫秒
+				if !exhausted {
+					exhausted = true
+					metrics.SnowflakeSequenceExhausted.Inc()
+				}
+				runtime.Gosched()
+				continue
+			}
+		}
+		if atomic.CompareAndSwapInt64(&s.state, old, next) {
+			if borrowed {
+				s.stats.inc(clockBorrowed)
+			} else if waited {
+				s.stats.inc(clockWaited)
+			}
+			return next | s.nodeBits, nil
+		}
+	}
+}
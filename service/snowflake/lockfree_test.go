@@ -0,0 +1,98 @@
+package snowflake
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSnowflake_LockFreeUnique(t *testing.T) {
+	g := New(Config{WorkerIdGetter: func() int64 { return 3 }, LockFree: true})
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	const goroutines, perGoroutine = 16, 20000
+	results := make([][]int64, goroutines)
+	wg := sync.WaitGroup{}
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perGoroutine; j++ {
+				id, err := g.Gen("")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				results[i] = append(results[i], id)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[int64]bool, goroutines*perGoroutine)
+	for _, ids := range results {
+		for i, id := range ids {
+			if seen[id] {
+				t.Fatalf("duplicate id:%d", id)
+			}
+			seen[id] = true
+			if i > 0 && id <= ids[i-1] { // 单个协程内递增
+				t.Fatalf("ids not increasing, %d <= %d", id, ids[i-1])
+			}
+			if info := Decode(id, 0); info.WorkerId != 3 {
+				t.Fatalf("unexpected workerId:%d", info.WorkerId)
+			}
+		}
+	}
+}
+
+func TestSnowflake_LockFreeClockPolicy(t *testing.T) {
+	now := curMilliseconds()
+	defer fakeClock(&now)()
+
+	g := New(Config{WorkerIdGetter: getworkerId, LockFree: true, ClockPolicy: ClockPolicyBorrow, MaxBackwardMs: 2})
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	s := g.(*snowflake)
+	_, _ = s.Gen("")
+	atomic.AddInt64(&now, -1)
+	var count int
+	for ; ; count++ {
+		if _, err := s.Gen(""); err != nil {
+			break
+		}
+	}
+	// 借用 now+1, now+2 两毫秒后失败
+	if stats := s.ClockStats(); stats.Borrowed == 0 || stats.Failed != 1 || count < 4096 {
+		t.Fatalf("unexpected stats:%+v, count:%d", stats, count)
+	}
+
+	// 等待后时钟仍未追上，回拨只计数一次
+	g = New(Config{WorkerIdGetter: getworkerId, LockFree: true, ClockPolicy: ClockPolicyWait, MaxBackwardMs: 5})
+	_ = g.Init()
+	s = g.(*snowflake)
+	_, _ = s.Gen("")
+	atomic.AddInt64(&now, -3)
+	if _, err := s.Gen(""); err == nil {
+		t.Fatal("expect error when clock not caught up")
+	}
+	if stats := s.ClockStats(); stats.Backwards != 1 || stats.Waited != 0 || stats.Failed != 1 {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+
+	g = New(Config{WorkerIdGetter: getworkerId, LockFree: true, ClockPolicy: ClockPolicyFailFast})
+	_ = g.Init()
+	_, _ = g.Gen("")
+	atomic.AddInt64(&now, -10)
+	if _, err := g.Gen(""); err == nil {
+		t.Fatal("expect error when clock moved backwards")
+	}
+
+	err := New(Config{WorkerIdGetter: getworkerId, LockFree: true, ClockPolicy: ClockPolicySpareWorker, SpareWorkerIds: []int64{1}}).Init()
+	if err == nil {
+		t.Fatal("expect error for spare worker policy")
+	}
+}
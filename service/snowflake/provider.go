@@ -0,0 +1,101 @@
+package snowflake
+
+import (
+	"fmt"
+	"github.com/longyufei109/leaf-go/log"
+	"github.com/longyufei109/leaf-go/service"
+	"time"
+)
+
+const defaultRenewInterval = 3 * time.Second
+
+// 从外部获取workerId，如zookeeper、etcd
+// Init 时调用 Acquire，运行中每隔 Config.RenewInterval 调用 Renew 和 ReportTimestamp，Shutdown 时上报最后的时间戳并调用 Release
+type WorkerIdProvider interface {
+	// 获取一个[0, maxWorkerId]范围内的workerId，需保证同一时间不会分配给其它节点
+	Acquire(maxWorkerId int64) (int64, error)
+	// 续约，失败时仅记录日志
+	Renew() error
+	// 释放workerId，之后可以分配给其它节点
+	Release() error
+	// 上报已使用的最大时间戳，workerId再次被获取时据此检查时钟回拨
+	ReportTimestamp(ts int64) error
+}
+
+// WorkerIdProvider 可选实现，Healthy 在无法确认workerId仍归本节点所有时返回错误，此时不再生成id
+// HealthDetails 合并到snowflake的健康检查信息中
+type HealthChecker = service.HealthChecker
+
+// 优先使用 Config.WorkerIdProvider
+func (s *snowflake) acquireWorkerId() (int64, error) {
+	if s.conf.WorkerIdProvider != nil {
+		workerId, err := s.conf.WorkerIdProvider.Acquire(s.conf.Layout.MaxWorkerId())
+		if err != nil {
+			return -1, fmt.Errorf("acquire workerId failed. err:%v", err)
+		}
+		return workerId, nil
+	}
+	if s.conf.WorkerIdGetter == nil {
+		return -1, fmt.Errorf("neither WorkerIdProvider nor WorkerIdGetter is configured")
+	}
+	return s.conf.WorkerIdGetter(), nil
+}
+
+func (s *snowflake) renewPeriodically(interval time.Duration) {
+	defer s.wg.Done()
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-tick.C:
+			if err := s.conf.WorkerIdProvider.Renew(); err != nil {
+				log.Print("[snowflake] renew workerId failed. err:%v", err)
+			}
+			if ts := s.usedTimestamp(); ts > 0 {
+				if err := s.conf.WorkerIdProvider.ReportTimestamp(ts); err != nil {
+					log.Print("[snowflake] report timestamp failed. err:%v", err)
+				}
+			}
+		}
+	}
+}
+
+func (s *snowflake) releaseWorkerId() {
+	p := s.conf.WorkerIdProvider
+	if ts := s.usedTimestamp(); ts > 0 {
+		if err := p.ReportTimestamp(ts); err != nil {
+			log.Print("[snowflake] report timestamp failed. err:%v", err)
+		}
+	}
+	if err := p.Release(); err != nil {
+		log.Print("[snowflake] release workerId failed. err:%v", err)
+	}
+}
+
+// 实现 service.HealthChecker，WorkerIdProvider 未实现时总是健康
+func (s *snowflake) Healthy() error {
+	if s.health == nil {
+		return nil
+	}
+	return s.health.Healthy()
+}
+
+func (s *snowflake) HealthDetails() map[string]interface{} {
+	s.lock.Lock()
+	workerId := s.workerId
+	s.lock.Unlock()
+	details := map[string]interface{}{
+		"mode":      "snowflake",
+		"worker_id": workerId,
+		"clock":     s.ClockStats(),
+	}
+	if s.health != nil {
+		for k, v := range s.health.HealthDetails() {
+			details[k] = v
+		}
+	}
+	return details
+}
@@ -0,0 +1,108 @@
+package snowflake
+
+import (
+	"errors"
+	"github.com/longyufei109/leaf-go/service"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeProvider struct {
+	lock        sync.Mutex
+	workerId    int64
+	maxWorkerId int64
+	renewed     int
+	reported    int64
+	released    bool
+}
+
+func (p *fakeProvider) Acquire(maxWorkerId int64) (int64, error) {
+	p.maxWorkerId = maxWorkerId
+	return p.workerId, nil
+}
+
+func (p *fakeProvider) Renew() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.renewed++
+	return nil
+}
+
+func (p *fakeProvider) Release() error {
+	p.released = true
+	return nil
+}
+
+func (p *fakeProvider) ReportTimestamp(ts int64) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.reported = ts
+	return nil
+}
+
+func TestWorkerIdProvider(t *testing.T) {
+	p := &fakeProvider{workerId: 7}
+	g := New(Config{WorkerIdProvider: p, RenewInterval: 10 * time.Millisecond})
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if p.maxWorkerId != DefaultLayout.MaxWorkerId() {
+		t.Fatalf("expect maxWorkerId %d, got %d", DefaultLayout.MaxWorkerId(), p.maxWorkerId)
+	}
+	id, err := g.Gen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := Decode(id, 0)
+	if info.WorkerId != 7 {
+		t.Fatalf("expect workerId 7, got %d", info.WorkerId)
+	}
+	time.Sleep(50 * time.Millisecond)
+	g.Shutdown()
+	if p.renewed == 0 || p.reported < info.Timestamp || !p.released {
+		t.Fatalf("renewed:%d reported:%d released:%v", p.renewed, p.reported, p.released)
+	}
+
+	// workerId超出范围时释放
+	p = &fakeProvider{workerId: DefaultLayout.MaxWorkerId() + 1}
+	if err := New(Config{WorkerIdProvider: p}).Init(); err == nil || !p.released {
+		t.Fatalf("expect invalid workerId error and released, err:%v", err)
+	}
+}
+
+type unhealthyProvider struct {
+	fakeProvider
+	err error
+}
+
+func (p *unhealthyProvider) Healthy() error {
+	return p.err
+}
+
+func (p *unhealthyProvider) HealthDetails() map[string]interface{} {
+	return map[string]interface{}{"provider": "fake"}
+}
+
+func TestWorkerIdProvider_Unhealthy(t *testing.T) {
+	p := &unhealthyProvider{}
+	g := New(Config{WorkerIdProvider: p})
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Shutdown()
+	if _, err := g.Gen(""); err != nil {
+		t.Fatal(err)
+	}
+	p.err = errors.New("workerId lost")
+	if _, err := g.Gen(""); err != p.err {
+		t.Fatalf("expect %v, got %v", p.err, err)
+	}
+	if _, err := g.GenBatch("", 10); err != p.err {
+		t.Fatalf("expect %v, got %v", p.err, err)
+	}
+	details := g.(service.HealthChecker).HealthDetails()
+	if details["provider"] != "fake" || details["worker_id"] != int64(0) {
+		t.Fatalf("unexpected details:%v", details)
+	}
+}
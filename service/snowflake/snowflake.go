@@ -1,9 +1,14 @@
-// 1bit not used | 41 bits for timestamp(ms) | 10 bits for workerId | 12 bits for sequence
+// 1bit not used | timestamp(ms) | datacenterId | workerId | sequence
+// 默认 41 bits timestamp | 10 bits workerId | 12 bits sequence，可通过 Config.Layout 调整，见 layout.go
 package snowflake
 
 import (
 	"fmt"
+	"github.com/longyufei109/leaf-go/entity"
+	"github.com/longyufei109/leaf-go/log"
+	"github.com/longyufei109/leaf-go/metrics"
 	"github.com/longyufei109/leaf-go/service"
+	"github.com/longyufei109/leaf-go/util"
 	"math/rand"
 	"runtime"
 	"sync"
@@ -16,18 +21,36 @@ func init() {
 }
 
 const (
-	defaultTewpoch int64 = 1603509071000 // 北京时间 2020-10-24 11:11:11
-	workerIdBits   int64 = 10
-	maxWorkerId    int64 = 1<<uint64(workerIdBits) - 1 // workerId: [0, 1023]
-	sequenceBits   int64 = 12
-	sequenceMask   int64 = 1<<uint64(sequenceBits) - 1 // 0x0FFF
-	workerIdShift        = uint64(sequenceBits)
-	timestampShift       = uint64(workerIdBits + sequenceBits)
+	DefaultTwepoch    int64 = 1603509071000 // 北京时间 2020-10-24 11:11:11，未配置 Config.Twepoch 时使用
+	maxRandomSequence int64 = 100           // 每毫秒的起始序列号在[0, 100)中随机
 )
 
 type Config struct {
 	Twepoch        int64
 	WorkerIdGetter func() int64
+	Layout         Layout // 未配置的位数使用 DefaultLayout 中的值
+	DatacenterId   int64  // Layout.DatacenterIdBits > 0 时有效
+
+	// 配置时代替 WorkerIdGetter，见 provider.go
+	WorkerIdProvider WorkerIdProvider
+	// 续约和上报时间戳的间隔，为0时使用默认值3s
+	RenewInterval time.Duration
+
+	// 定期保存已使用的最大时间戳，重启时等待系统时间超过该值后才提供服务，避免重启前后时钟回拨生成重复id
+	// 为nil时不保存
+	TimestampStore TimestampStore
+	// 启动时最多等待多久，超过则 Init 返回 *ClockBackwardsError，为0时使用默认值5s
+	MaxStartupWait time.Duration
+
+	// 运行中时钟回拨的处理策略，见 clock.go
+	ClockPolicy ClockPolicy
+	// ClockPolicyWait 时最多等待的毫秒数，ClockPolicyBorrow 时最多领先系统时间的毫秒数，为0时使用默认值5
+	MaxBackwardMs int64
+	// ClockPolicySpareWorker 时使用的备用workerId，需保证不会被其它节点使用，不能与 WorkerIdProvider 同时使用
+	SpareWorkerIds []int64
+
+	// 使用无锁实现，见 lockfree.go
+	LockFree bool
 }
 
 type snowflake struct {
@@ -36,75 +59,317 @@ type snowflake struct {
 	lock          sync.Locker
 	sequence      int64
 	lastTimestamp int64
+	highWater     int64 // 已使用过的最大时间戳，切换workerId或借用未来时间时可能大于lastTimestamp
+	stop          chan struct{}
+	wg            sync.WaitGroup
+	health        HealthChecker // WorkerIdProvider 实现了 HealthChecker 时使用
+	initialized   util.AtomicBool
+
+	primaryWorkerId  int64
+	workerTimestamps map[int64]int64 // ClockPolicySpareWorker 时各workerId最后使用的时间戳
+	stats            clockStats
+
+	// LockFree 时使用
+	state    int64 // (timestamp - twepoch) << timestampShift | sequence
+	nodeBits int64 // datacenterId 和 workerId 部分
+
+	// Init 时根据 conf.Layout 计算
+	layout            Layout
+	sequenceMask      int64
+	maxTimestamp      int64
+	randomSequence    int64
+	workerIdShift     uint64
+	datacenterIdShift uint64
+	timestampShift    uint64
 }
 
 func New(conf Config) service.IdGenerator {
-	if conf.Twepoch <= 0 || conf.Twepoch > curMilliseconds() {
-		conf.Twepoch = defaultTewpoch
+	if conf.Twepoch <= 0 {
+		conf.Twepoch = DefaultTwepoch
 	}
-
-	workerId := conf.WorkerIdGetter()
-	if workerId < 0 || workerId > maxWorkerId {
-		panic(fmt.Sprintf("invalid workerId:%d", workerId))
+	// 未配置的部分使用默认值
+	if conf.Layout.WorkerIdBits == 0 {
+		conf.Layout.WorkerIdBits = DefaultLayout.WorkerIdBits
+	}
+	if conf.Layout.SequenceBits == 0 {
+		conf.Layout.SequenceBits = DefaultLayout.SequenceBits
+	}
+	if conf.MaxStartupWait <= 0 {
+		conf.MaxStartupWait = defaultMaxStartupWait
+	}
+	if conf.MaxBackwardMs <= 0 {
+		conf.MaxBackwardMs = defaultMaxBackwardMs
+	}
+	if conf.RenewInterval <= 0 {
+		conf.RenewInterval = defaultRenewInterval
 	}
 
 	g := &snowflake{
-		conf:     conf,
-		workerId: workerId,
-		lock:     new(caslock),
+		conf: conf,
+		lock: new(caslock),
+		stop: make(chan struct{}),
 	}
 	return g
 }
 
-func (s *snowflake) Init() error {
+func (s *snowflake) Init() (err error) {
+	if s.conf.Twepoch > curMilliseconds() {
+		return fmt.Errorf("invalid twepoch:%d, should not be in the future", s.conf.Twepoch)
+	}
+	l := s.conf.Layout
+	if err := l.Validate(s.conf.Twepoch); err != nil {
+		return err
+	}
+	if s.conf.DatacenterId < 0 || s.conf.DatacenterId > l.MaxDatacenterId() {
+		return fmt.Errorf("invalid datacenterId:%d, should be in [0, %d]", s.conf.DatacenterId, l.MaxDatacenterId())
+	}
+	workerId, err := s.acquireWorkerId()
+	if err != nil {
+		return err
+	}
+	if s.conf.WorkerIdProvider != nil {
+		s.health, _ = s.conf.WorkerIdProvider.(HealthChecker)
+		defer func() {
+			if err != nil {
+				_ = s.conf.WorkerIdProvider.Release()
+			}
+		}()
+	}
+	if workerId < 0 || workerId > l.MaxWorkerId() {
+		return fmt.Errorf("invalid workerId:%d, should be in [0, %d]", workerId, l.MaxWorkerId())
+	}
+
+	s.workerId = workerId
+	s.primaryWorkerId = workerId
+	s.layout = l
+	s.sequenceMask = l.SequenceMask()
+	s.maxTimestamp = l.MaxTimestamp()
+	s.randomSequence = maxRandomSequence
+	if s.randomSequence > s.sequenceMask { // 序列号位数较少时，保留至少一半的序列号
+		s.randomSequence = (s.sequenceMask + 1) / 2
+	}
+	s.workerIdShift = l.workerIdShift()
+	s.datacenterIdShift = l.datacenterIdShift()
+	s.timestampShift = l.timestampShift()
+	if err := s.initClockPolicy(); err != nil {
+		return err
+	}
+	if s.conf.LockFree {
+		if err := s.initLockFree(); err != nil {
+			return err
+		}
+	}
+
+	if s.conf.TimestampStore != nil {
+		if err := s.waitPersistedTimestamp(); err != nil {
+			return err
+		}
+		s.wg.Add(1)
+		go s.persistPeriodically(timestampPersistInterval)
+	}
+	if s.conf.WorkerIdProvider != nil {
+		s.wg.Add(1)
+		go s.renewPeriodically(s.conf.RenewInterval)
+	}
+	s.initialized.Set(true)
+	return nil
+}
+
+// 上次保存之后到进程退出之前，最多还可能使用 timestampPersistInterval 内的时间戳
+// 所以需等待当前时间超过 保存的时间戳 + timestampPersistInterval
+func (s *snowflake) waitPersistedTimestamp() error {
+	persisted, err := s.conf.TimestampStore.Load()
+	if err != nil {
+		return err
+	}
+	if persisted <= 0 {
+		return nil
+	}
+	last := persisted + int64(timestampPersistInterval/time.Millisecond)
+	now := curMilliseconds()
+	if now <= last {
+		wait := time.Duration(last-now+1) * time.Millisecond
+		if wait > s.conf.MaxStartupWait {
+			return &ClockBackwardsError{Last: last, Now: now}
+		}
+		log.Print("[snowflake] clock is behind persisted timestamp, wait %v", wait)
+		time.Sleep(wait)
+	}
+	s.lastTimestamp = last
+	s.highWater = last
+	if s.conf.LockFree {
+		s.resetLockFreeState(last)
+	}
 	return nil
 }
 
-func (s *snowflake) Gen(_ string) (id int64, err error) {
+func (s *snowflake) persistPeriodically(interval time.Duration) {
+	defer s.wg.Done()
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-tick.C:
+			s.persistTimestamp()
+		}
+	}
+}
+
+// 已使用的最大时间戳，尚未生成过id时为0
+func (s *snowflake) usedTimestamp() int64 {
+	if s.conf.LockFree {
+		return s.lockFreeTimestamp()
+	}
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	return s.highWater
+}
 
+func (s *snowflake) persistTimestamp() {
+	ts := s.usedTimestamp()
+	if ts <= 0 {
+		return
+	}
+	if err := s.conf.TimestampStore.Store(ts); err != nil {
+		log.Print("[snowflake] persist timestamp failed. err:%v", err)
+	}
+}
+
+func (s *snowflake) Gen(_ string) (id int64, err error) {
+	if err = s.Healthy(); err != nil {
+		countError(metrics.ErrorUnhealthy)
+		return -1, err
+	}
+	if s.conf.LockFree {
+		id, err = s.nextIdLockFree()
+	} else {
+		s.lock.Lock()
+		id, err = s.nextId()
+		s.lock.Unlock()
+	}
+	countResult(1, err)
+	return
+}
+
+// 在同一次加锁中连续生成n个id，无锁实现时逐个生成
+func (s *snowflake) GenBatch(_ string, n int) (ids []int64, err error) {
+	if n <= 0 || n > service.MaxBatchSize {
+		countError(metrics.ErrorInvalidCount)
+		return nil, fmt.Errorf("%w, invalid count:%d", service.ErrInvalidArgument, n)
+	}
+	if err = s.Healthy(); err != nil {
+		countError(metrics.ErrorUnhealthy)
+		return nil, err
+	}
+	next := s.nextId
+	if s.conf.LockFree {
+		next = s.nextIdLockFree
+	} else {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+	}
+
+	ids = make([]int64, 0, n)
+	for i := 0; i < n; i++ {
+		id, err := next()
+		if err != nil {
+			countResult(0, err)
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	countResult(n, nil)
+	return ids, nil
+}
+
+// snowflake不区分key，标签固定，预先取出
+var (
+	idsIssued           = metrics.IdsIssued.WithLabelValues(metrics.ModeSnowflake, "")
+	clockBackwardsCount = metrics.IdErrors.WithLabelValues(metrics.ModeSnowflake, "", metrics.ErrorClockBackwards)
+	overflowCount       = metrics.IdErrors.WithLabelValues(metrics.ModeSnowflake, "", metrics.ErrorOverflow)
+)
+
+// nextId 只返回 *ClockBackwardsError 和时间戳溢出两种错误
+func countResult(n int, err error) {
+	if err == nil {
+		idsIssued.Add(float64(n))
+	} else if _, ok := err.(*ClockBackwardsError); ok {
+		clockBackwardsCount.Inc()
+	} else {
+		overflowCount.Inc()
+	}
+}
+
+func countError(typ string) {
+	metrics.IdErrors.WithLabelValues(metrics.ModeSnowflake, "", typ).Inc()
+}
+
+// 加锁保护时调用
+func (s *snowflake) nextId() (id int64, err error) {
 	now := curMilliseconds()
-	if now < s.lastTimestamp { // 时钟回拨
-		offset := s.lastTimestamp - now
-		if offset < 5 { // 回拨不超过5毫秒
-			time.Sleep(time.Duration(offset << 1))
-			now = curMilliseconds()
-			if now < s.lastTimestamp { // 通常 now > s.lastTimestamp
-				id = -1
-				err = fmt.Errorf("1. machine time changed extremely")
-				return
-			}
-		} else {
-			// 后续的请求都将返回-2，因为不再更新s.lastTimestamp
-			// 重启后程序恢复正常，但仍可能生成重复id(机器当前时间可能小于重启前的s.lastTimestamp)
-			id = -2
-			err = fmt.Errorf("2. machine time changed extremely")
-			return
+	if now < s.lastTimestamp { // 时钟回拨，按 ClockPolicy 处理
+		// 无法处理时，在时钟追上s.lastTimestamp之前，后续的请求都将失败，因为不再更新s.lastTimestamp
+		// 配置了TimestampStore时，重启后同样会等待时钟追上，不会生成重复id
+		if now, err = s.handleClockBackwards(now); err != nil {
+			return -1, err
 		}
 	}
 
 	if s.lastTimestamp == now {
-		s.sequence = (s.sequence + 1) & sequenceMask
+		s.sequence = (s.sequence + 1) & s.sequenceMask
 		if s.sequence == 0 { // 当前这1毫秒内的序列号用尽了
-			for now <= s.lastTimestamp { // 循环 直到 下一毫秒
-				now = curMilliseconds()
+			if now, err = s.tilNextMillis(); err != nil {
+				return -1, err
 			}
-			s.sequence = randomSequence(100)
+			s.sequence = randomSequence(s.randomSequence)
 		}
 	} else { // 全新的时间戳
-		s.sequence = randomSequence(100)
+		s.sequence = randomSequence(s.randomSequence)
+	}
+	if now-s.conf.Twepoch > s.maxTimestamp {
+		id = -1
+		err = fmt.Errorf("timestamp overflow, layout:%+v", s.layout)
+		return
 	}
 	s.lastTimestamp = now
-	id = ((now - s.conf.Twepoch) << timestampShift) | (s.workerId << workerIdShift) | s.sequence
+	if now > s.highWater {
+		s.highWater = now
+	}
+	id = ((now - s.conf.Twepoch) << s.timestampShift) | (s.conf.DatacenterId << s.datacenterIdShift) |
+		(s.workerId << s.workerIdShift) | s.sequence
 	return
 }
 
-func (s *snowflake) Shutdown() {
+func (s *snowflake) Decode(id int64) (entity.IdInfo, error) {
+	if id <= 0 {
+		return entity.IdInfo{}, fmt.Errorf("invalid id:%d", id)
+	}
+	return s.conf.Layout.Decode(id, s.conf.Twepoch), nil
+}
 
+// 按默认布局将id拆分为时间戳、workerId和序列号，twepoch需与生成id时一致，<=0 时使用默认值
+// 自定义布局使用 Layout.Decode
+func Decode(id int64, twepoch int64) entity.IdInfo {
+	return DefaultLayout.Decode(id, twepoch)
+}
+
+func (s *snowflake) Shutdown() {
+	s.initialized.Set(false)
+	close(s.stop)
+	s.wg.Wait()
+	if s.conf.TimestampStore != nil {
+		s.persistTimestamp()
+	}
+	if s.conf.WorkerIdProvider != nil {
+		s.releaseWorkerId()
+	}
 }
 
-func curMilliseconds() int64 {
+// 变量形式便于测试时模拟时钟回拨
+var curMilliseconds = func() int64 {
 	return time.Now().UnixNano() / 1e6
 }
 
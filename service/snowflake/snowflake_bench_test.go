@@ -0,0 +1,73 @@
+package snowflake
+
+import (
+	"fmt"
+	"testing"
+)
+
+// go test -run=^$ -bench=. -cpu=1,4,8 ./service/snowflake/
+// 比较加锁实现与无锁实现在不同并发度下的吞吐
+
+func benchmarkGen(b *testing.B, lockFree bool, parallelism int) {
+	g := New(Config{WorkerIdGetter: getworkerId, LockFree: lockFree})
+	if err := g.Init(); err != nil {
+		b.Fatal(err)
+	}
+	b.SetParallelism(parallelism)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := g.Gen(""); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkGen(b *testing.B) {
+	for _, parallelism := range []int{1, 4, 16, 64} { // 每个P上的协程数
+		for _, impl := range []struct {
+			name     string
+			lockFree bool
+		}{{"Lock", false}, {"LockFree", true}} {
+			b.Run(fmt.Sprintf("%s/goroutinesPerProc=%d", impl.name, parallelism), func(b *testing.B) {
+				benchmarkGen(b, impl.lockFree, parallelism)
+			})
+		}
+	}
+}
+
+func BenchmarkGenSerial(b *testing.B) {
+	for _, impl := range []struct {
+		name     string
+		lockFree bool
+	}{{"Lock", false}, {"LockFree", true}} {
+		b.Run(impl.name, func(b *testing.B) {
+			g := New(Config{WorkerIdGetter: getworkerId, LockFree: impl.lockFree})
+			_ = g.Init()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = g.Gen("")
+			}
+		})
+	}
+}
+
+func BenchmarkGenBatch(b *testing.B) {
+	for _, impl := range []struct {
+		name     string
+		lockFree bool
+	}{{"Lock", false}, {"LockFree", true}} {
+		b.Run(impl.name, func(b *testing.B) {
+			g := New(Config{WorkerIdGetter: getworkerId, LockFree: impl.lockFree})
+			_ = g.Init()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, _ = g.GenBatch("", 100)
+				}
+			})
+		})
+	}
+}
@@ -46,3 +46,49 @@ func TestSnowflake_Gen(t *testing.T) {
 func getworkerId() int64 {
 	return 0
 }
+
+func TestSnowflake_GenBatch(t *testing.T) {
+	g := New(Config{WorkerIdGetter: getworkerId})
+	_ = g.Init()
+
+	ids, err := g.GenBatch("", 5000)
+	if err != nil {
+		t.Fatalf("GenBatch failed, err:%v", err)
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Fatalf("ids not increasing, %d <= %d", ids[i], ids[i-1])
+		}
+	}
+	if _, err = g.GenBatch("", 0); err == nil {
+		t.Fatal("expect error for count 0")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	g := New(Config{WorkerIdGetter: func() int64 { return 513 }})
+	_ = g.Init()
+
+	before := curMilliseconds()
+	id, _ := g.Gen("")
+	info, err := g.(*snowflake).Decode(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.WorkerId != 513 || info.Sequence > DefaultLayout.SequenceMask() {
+		t.Fatalf("unexpected info:%+v", info)
+	}
+	if info.Timestamp < before || info.Timestamp > curMilliseconds() {
+		t.Fatalf("unexpected timestamp:%d", info.Timestamp)
+	}
+	if info.Time.UnixNano()/1e6 != info.Timestamp {
+		t.Fatalf("time mismatch:%v, %d", info.Time, info.Timestamp)
+	}
+}
+
+func TestSnowflake_FutureTwepoch(t *testing.T) {
+	g := New(Config{Twepoch: curMilliseconds() + 60*1000, WorkerIdGetter: getworkerId})
+	if err := g.Init(); err == nil {
+		t.Fatal("expect error for twepoch in the future")
+	}
+}
@@ -0,0 +1,72 @@
+package snowflake
+
+import (
+	"errors"
+	"time"
+)
+
+// 实现 service.ReadinessChecker: 已获取workerId、workerId仍归本节点所有，且时钟回拨在 ClockPolicy 可处理的范围内
+func (s *snowflake) Ready() error {
+	if !s.initialized.True() {
+		return errors.New("snowflake not initialized or already shutdown")
+	}
+	if err := s.Healthy(); err != nil {
+		return err
+	}
+	now := curMilliseconds()
+	if s.conf.LockFree {
+		return s.checkClock(s.lockFreeTimestamp(), now)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conf.ClockPolicy == ClockPolicySpareWorker && s.lastTimestamp > now {
+		for id, ts := range s.workerTimestamps {
+			if id != s.workerId && ts < now {
+				return nil // 可以切换到该workerId
+			}
+		}
+	}
+	return s.checkClock(s.lastTimestamp, now)
+}
+
+func (s *snowflake) checkClock(last, now int64) error {
+	var tolerance int64
+	if s.conf.ClockPolicy == ClockPolicyWait || s.conf.ClockPolicy == ClockPolicyBorrow {
+		tolerance = s.conf.MaxBackwardMs
+	}
+	if last-now > tolerance {
+		return &ClockBackwardsError{Last: last, Now: now}
+	}
+	return nil
+}
+
+// 实现 service.StatusReporter
+func (s *snowflake) Status() map[string]interface{} {
+	var workerId, lastTimestamp int64
+	if s.conf.LockFree {
+		workerId, lastTimestamp = s.primaryWorkerId, s.lockFreeTimestamp()
+	} else {
+		s.lock.Lock()
+		workerId, lastTimestamp = s.workerId, s.lastTimestamp
+		s.lock.Unlock()
+	}
+	return map[string]interface{}{
+		"mode":              "snowflake",
+		"initialized":       s.initialized.True(),
+		"worker_id":         workerId,
+		"primary_worker_id": s.primaryWorkerId,
+		"datacenter_id":     s.conf.DatacenterId,
+		"twepoch":           s.conf.Twepoch,
+		"twepoch_time":      time.Unix(0, s.conf.Twepoch*1e6).Format(time.RFC3339),
+		"layout": map[string]int64{
+			"timestamp_bits":     s.layout.TimestampBits(),
+			"datacenter_id_bits": s.layout.DatacenterIdBits,
+			"worker_id_bits":     s.layout.WorkerIdBits,
+			"sequence_bits":      s.layout.SequenceBits,
+		},
+		"last_timestamp": lastTimestamp,
+		"clock_policy":   s.conf.ClockPolicy,
+		"lock_free":      s.conf.LockFree,
+		"clock":          s.ClockStats(),
+	}
+}
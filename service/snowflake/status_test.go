@@ -0,0 +1,44 @@
+package snowflake
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestSnowflake_Ready(t *testing.T) {
+	now := curMilliseconds()
+	defer fakeClock(&now)()
+	g := New(Config{WorkerIdGetter: getworkerId, ClockPolicy: ClockPolicyWait, MaxBackwardMs: 5}).(*snowflake)
+	if err := g.Ready(); err == nil {
+		t.Fatal("expect not ready before Init")
+	}
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Gen(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Ready(); err != nil {
+		t.Fatalf("expect ready, err:%v", err)
+	}
+
+	// 回拨在可等待的范围内仍就绪
+	atomic.AddInt64(&now, -5)
+	if err := g.Ready(); err != nil {
+		t.Fatalf("expect ready, err:%v", err)
+	}
+	atomic.AddInt64(&now, -1)
+	if _, ok := g.Ready().(*ClockBackwardsError); !ok {
+		t.Fatalf("expect *ClockBackwardsError, got %v", g.Ready())
+	}
+	atomic.AddInt64(&now, 6)
+
+	status := g.Status()
+	if status["worker_id"] != getworkerId() || status["last_timestamp"] != now || status["initialized"] != true {
+		t.Fatalf("unexpected status:%+v", status)
+	}
+	g.Shutdown()
+	if err := g.Ready(); err == nil {
+		t.Fatal("expect not ready after Shutdown")
+	}
+}
@@ -0,0 +1,69 @@
+package snowflake
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	timestampPersistInterval = time.Second     // 持久化已使用时间戳的间隔
+	defaultMaxStartupWait    = 5 * time.Second // 启动时等待时钟追上已使用时间戳的最长时间
+)
+
+// 时钟回拨：当前时间小于已经使用过的时间戳，继续生成id可能重复
+type ClockBackwardsError struct {
+	Last int64 // 已使用过的最大时间戳，毫秒
+	Now  int64
+}
+
+func (e *ClockBackwardsError) Error() string {
+	return fmt.Sprintf("clock moved backwards, refuse to generate id for %dms. last:%d, now:%d", e.Last-e.Now, e.Last, e.Now)
+}
+
+// 持久化已使用的最大时间戳，重启后据此判断时钟是否回拨
+type TimestampStore interface {
+	// 未保存过时返回0
+	Load() (int64, error)
+	Store(ts int64) error
+}
+
+type fileTimestampStore struct {
+	path string
+}
+
+// 将时间戳保存在本地文件中，内容为毫秒时间戳
+func NewFileTimestampStore(path string) TimestampStore {
+	return &fileTimestampStore{path: path}
+}
+
+func (f *fileTimestampStore) Load() (int64, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	ts, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp file:%s, err:%v", f.path, err)
+	}
+	return ts, nil
+}
+
+// 先写临时文件再重命名，避免进程崩溃时留下不完整的文件
+func (f *fileTimestampStore) Store(ts int64) error {
+	if err := os.MkdirAll(filepath.Dir(f.path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.FormatInt(ts, 10)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
@@ -0,0 +1,57 @@
+package snowflake
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileTimestampStore(t *testing.T) {
+	store := NewFileTimestampStore(filepath.Join(t.TempDir(), "sub", "ts"))
+	if ts, err := store.Load(); err != nil || ts != 0 {
+		t.Fatalf("expect 0 before store, ts:%d, err:%v", ts, err)
+	}
+	if err := store.Store(1603509071000); err != nil {
+		t.Fatal(err)
+	}
+	if ts, err := store.Load(); err != nil || ts != 1603509071000 {
+		t.Fatalf("unexpected ts:%d, err:%v", ts, err)
+	}
+}
+
+func TestSnowflake_PersistedTimestamp(t *testing.T) {
+	store := NewFileTimestampStore(filepath.Join(t.TempDir(), "ts"))
+	conf := Config{WorkerIdGetter: getworkerId, TimestampStore: store}
+
+	g := New(conf)
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	id, _ := g.Gen("")
+	g.Shutdown()
+	persisted, _ := store.Load()
+	if persisted != Decode(id, 0).Timestamp {
+		t.Fatalf("expect persisted %d, got %d", Decode(id, 0).Timestamp, persisted)
+	}
+
+	// 重启后需等待时钟超过 保存的时间戳 + timestampPersistInterval
+	start := time.Now()
+	g = New(conf)
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < timestampPersistInterval/2 {
+		t.Fatalf("expect waiting for persisted timestamp, waited %v", time.Since(start))
+	}
+	if next, _ := g.Gen(""); Decode(next, 0).Timestamp <= persisted+int64(timestampPersistInterval/time.Millisecond) {
+		t.Fatalf("id timestamp not after persisted timestamp")
+	}
+	g.Shutdown()
+
+	// 模拟时钟大幅回拨
+	_ = store.Store(curMilliseconds() + time.Hour.Milliseconds())
+	err := New(conf).Init()
+	if _, ok := err.(*ClockBackwardsError); !ok {
+		t.Fatalf("expect *ClockBackwardsError, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package zookeeper
+
+import (
+	"fmt"
+	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/log"
+	"github.com/longyufei109/leaf-go/service/snowflake"
+	"github.com/samuel/go-zookeeper/zk"
+	"sort"
+	"time"
+)
+
+// 回收节点的最短未上报天数，本地缓存的workerId超过该时间未上报时不再使用
+const minReclaimDays = 1
+
+// 已注册的节点
+type Worker struct {
+	WorkerId      int64  `json:"worker_id"`
+	Node          string `json:"node"`
+	Ip            string `json:"ip"`
+	Port          string `json:"port"`
+	Timestamp     int64  `json:"timestamp"`      // 节点上报的时间戳
+	LastHeartbeat int64  `json:"last_heartbeat"` // 节点的最后修改时间(zk服务端时间)，毫秒
+	version       int32
+}
+
+// 管理 /snowflake/{leafName}/forever 下的节点，每次操作单独建立连接
+type Admin struct {
+	provider *WorkerIdProvider
+}
+
+// workerIdBits <= 0 时使用 snowflake.DefaultLayout
+func NewAdmin(zconf *config.Zookeeper, workerIdBits int64) *Admin {
+	if workerIdBits <= 0 {
+		workerIdBits = snowflake.DefaultLayout.WorkerIdBits
+	}
+	p := NewWorkerIdProvider(zconf, 0)
+	p.maxWorkerId = snowflake.Layout{WorkerIdBits: workerIdBits}.MaxWorkerId()
+	return &Admin{provider: p}
+}
+
+// 按workerId排序
+func (a *Admin) Workers() ([]Worker, error) {
+	conn, _, err := connect(&a.provider.conf)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return a.workers(conn)
+}
+
+func (a *Admin) workers(conn *zk.Conn) ([]Worker, error) {
+	keys, _, err := conn.Children(a.provider.foreverPath)
+	if err == zk.ErrNoNode {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	workers := make([]Worker, 0, len(keys))
+	for _, key := range keys {
+		workerId, err := a.provider.nodeWorkerId(key)
+		if err != nil {
+			continue
+		}
+		node := a.provider.foreverPath + "/" + key
+		data, stat, err := conn.Get(node)
+		if err == zk.ErrNoNode {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		w := Worker{WorkerId: workerId, Node: node, LastHeartbeat: stat.Mtime, version: stat.Version}
+		if endpoint, err := Decode(data); err == nil {
+			w.Ip, w.Port, w.Timestamp = endpoint.Ip, endpoint.Port, endpoint.Timestamp
+		}
+		workers = append(workers, w)
+	}
+	sort.Slice(workers, func(i, j int) bool {
+		if workers[i].WorkerId != workers[j].WorkerId {
+			return workers[i].WorkerId < workers[j].WorkerId
+		}
+		return workers[i].Node < workers[j].Node
+	})
+	return workers, nil
+}
+
+// 删除超过days天未上报时间戳的节点，其workerId可被新节点复用，返回被删除(dryRun为true时为将被删除)的节点
+// 节点被删除后原持有者重启时会重新注册，仍在运行的原持有者上报时间戳时发现节点不存在，不再生成id
+func (a *Admin) Reclaim(days int, dryRun bool) ([]Worker, error) {
+	if days < minReclaimDays {
+		return nil, fmt.Errorf("invalid days:%d, should be at least %d", days, minReclaimDays)
+	}
+	conn, _, err := connect(&a.provider.conf)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	workers, err := a.workers(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(-time.Duration(days)*24*time.Hour).UnixNano() / 1e6
+	var reclaimed []Worker
+	for _, w := range workers {
+		if w.LastHeartbeat >= deadline || w.Timestamp >= deadline {
+			continue
+		}
+		if !dryRun {
+			// 指定版本，期间有更新时不删除
+			if err = conn.Delete(w.Node, w.version); err == zk.ErrBadVersion || err == zk.ErrNoNode {
+				continue
+			}
+			if err != nil {
+				return reclaimed, err
+			}
+			log.Print("[zookeeper] reclaim workerId:%d, node:%s, last heartbeat:%s", w.WorkerId, w.Node,
+				time.Unix(0, w.LastHeartbeat*1e6).Format(time.RFC3339))
+		}
+		reclaimed = append(reclaimed, w)
+	}
+	return reclaimed, nil
+}
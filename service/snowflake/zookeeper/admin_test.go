@@ -0,0 +1,71 @@
+package zookeeper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdmin_Reclaim(t *testing.T) {
+	s := newTestServer(t)
+	const maxWorkerId = 3
+	admin := NewAdmin(&newTestProvider(s, "").conf, 2)
+
+	nodes := map[string]string{}
+	for _, port := range []string{"8001", "8002", "8003", "8004"} {
+		p := newTestProvider(s, port)
+		if _, err := p.Acquire(maxWorkerId); err != nil {
+			t.Fatal(err)
+		}
+		nodes[port] = p.node
+		_ = p.Release()
+	}
+	if _, err := newTestProvider(s, "8005").Acquire(maxWorkerId); err == nil {
+		t.Fatal("expect no free workerId error")
+	}
+	workers, err := admin.Workers()
+	if err != nil || len(workers) != 4 || workers[1].Port != "8002" || workers[1].LastHeartbeat == 0 {
+		t.Fatalf("unexpected workers:%+v, err:%v", workers, err)
+	}
+
+	// 8002 已两天未上报
+	old := time.Now().Add(-48*time.Hour).UnixNano() / 1e6
+	data, _ := (&Endpoint{workers[1].Ip, "8002", old, admin.provider.twepoch}).Encode()
+	if err = s.Set(nodes["8002"], data); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetMtime(nodes["8002"], old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = admin.Reclaim(0, true); err == nil {
+		t.Fatal("expect invalid days error")
+	}
+	for days, n := range map[int]int{3: 0, 1: 1} {
+		reclaimed, err := admin.Reclaim(days, true)
+		if err != nil || len(reclaimed) != n {
+			t.Fatalf("days:%d, unexpected reclaimed:%+v, err:%v", days, reclaimed, err)
+		}
+	}
+	if _, ok := s.Get(nodes["8002"]); !ok {
+		t.Fatal("node deleted in dry run")
+	}
+	if reclaimed, err := admin.Reclaim(1, false); err != nil || len(reclaimed) != 1 || reclaimed[0].WorkerId != 1 {
+		t.Fatalf("unexpected reclaimed:%+v, err:%v", reclaimed, err)
+	}
+
+	// 新节点复用被回收的workerId
+	p := newTestProvider(s, "8005")
+	id, err := p.Acquire(maxWorkerId)
+	if err != nil || id != 1 {
+		t.Fatalf("expect reused workerId 1, got %d, err:%v", id, err)
+	}
+	_ = p.Release()
+	if seq, _ := parseSequence(p.node); seq <= maxWorkerId {
+		t.Fatalf("unexpected node:%s", p.node)
+	}
+	// 重启后仍使用同一个workerId
+	p = newTestProvider(s, "8005")
+	if id, err = p.Acquire(maxWorkerId); err != nil || id != 1 {
+		t.Fatalf("expect workerId 1 after restart, got %d, err:%v", id, err)
+	}
+	_ = p.Release()
+}
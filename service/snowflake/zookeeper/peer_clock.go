@@ -0,0 +1,94 @@
+package zookeeper
+
+import (
+	"fmt"
+	"github.com/samuel/go-zookeeper/zk"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxPeerClockSkew int64 = 5000
+	// 节点的修改时间(zk服务端时间)早于本节点超过该值时，认为已停止，不参与比较
+	peerAliveWindow = 30 * time.Second
+)
+
+// 启动时与其它存活节点的时钟偏差检查结果
+type PeerClockCheck struct {
+	Peers     int    `json:"peers"`   // 参与比较的存活节点数
+	SkewMs    int64  `json:"skew_ms"` // 本机时间 - 存活节点时间的平均值
+	MaxSkewMs int64  `json:"max_skew_ms"`
+	Passed    bool   `json:"passed"`
+	Skipped   string `json:"skipped,omitempty"` // 未检查的原因
+}
+
+// 与美团Leaf一致，比较本机时间与其它节点时间的平均值，偏差过大时拒绝启动
+// 各节点定期将当前时间写入自己的节点，以节点的修改时间(zk服务端时间)为参照，将其时间推算到本节点检查的时刻
+// 在注册前检查，以临时节点的创建时间为本节点的参照，检查不通过时不写入永久节点，避免留下超前的时间戳导致之后无法启动
+func (p *WorkerIdProvider) checkPeerClock(conn *zk.Conn) (PeerClockCheck, error) {
+	result := PeerClockCheck{MaxSkewMs: p.conf.MaxPeerClockSkew}
+	if result.MaxSkewMs == 0 {
+		result.MaxSkewMs = defaultMaxPeerClockSkew
+	}
+	if result.MaxSkewMs < 0 {
+		result.Skipped = "disabled"
+		return result, nil
+	}
+
+	if err := ensurePath(conn, p.foreverPath); err != nil {
+		return result, fmt.Errorf("create path %s failed. err:%v", p.foreverPath, err)
+	}
+	now := curMilliseconds()
+	probe, err := conn.Create(path.Dir(p.foreverPath)+"/probe-", nil, zk.FlagEphemeral|zk.FlagSequence,
+		zk.WorldACL(zk.PermAll))
+	if err != nil {
+		return result, fmt.Errorf("create probe node failed. err:%v", err)
+	}
+	defer conn.Delete(probe, -1)
+	_, self, err := conn.Exists(probe)
+	if err != nil {
+		return result, err
+	}
+	keys, _, err := conn.Children(p.foreverPath)
+	if err != nil {
+		return result, err
+	}
+
+	var sum int64
+	for _, key := range keys {
+		if strings.HasPrefix(key, p.listenAddress+"-") { // 本节点
+			continue
+		}
+		data, stat, err := conn.Get(p.foreverPath + "/" + key)
+		if err == zk.ErrNoNode {
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		endpoint, err := Decode(data)
+		if err != nil {
+			continue
+		}
+		elapsed := self.Mtime - stat.Mtime
+		if elapsed > int64(peerAliveWindow/time.Millisecond) || elapsed < 0 {
+			continue
+		}
+		sum += endpoint.Timestamp + elapsed
+		result.Peers++
+	}
+	if result.Peers == 0 {
+		result.Passed = true
+		result.Skipped = "no alive peers"
+		return result, nil
+	}
+
+	result.SkewMs = now - sum/int64(result.Peers)
+	result.Passed = result.SkewMs <= result.MaxSkewMs && result.SkewMs >= -result.MaxSkewMs
+	if !result.Passed {
+		return result, fmt.Errorf("local clock deviates %dms from the average of %d peers, max:%dms",
+			result.SkewMs, result.Peers, result.MaxSkewMs)
+	}
+	return result, nil
+}
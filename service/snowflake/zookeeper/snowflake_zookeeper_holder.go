@@ -2,279 +2,684 @@ package zookeeper
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/log"
 	"github.com/longyufei109/leaf-go/service"
 	"github.com/longyufei109/leaf-go/service/snowflake"
+	"github.com/longyufei109/leaf-go/util"
 	"github.com/samuel/go-zookeeper/zk"
-	"log"
-	"net"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type zconf struct {
-	zkAddress     string
-	listenAddress string
-	workID        int
+const sessionTimeout = 6 * time.Second
+
+// 变量形式便于测试时模拟时钟
+var curMilliseconds = func() int64 {
+	return time.Now().UnixNano() / 1e6
 }
 
+// 变量形式便于测试
+var (
+	connectTimeout        = sessionTimeout
+	registerRetryInterval = 10 * time.Second // 使用本地缓存的workerId启动后，重试注册到zk的间隔
+)
+
 type Endpoint struct {
 	Ip        string `json:"ip"`
 	Port      string `json:"port"`
 	Timestamp int64  `json:"timestamp"`
+	Twepoch   int64  `json:"twepoch,omitempty"`
 }
 
-var (
-	zkAddressNode    string
-	listenAddress    string
-	workID           int64
-	PREFIX_ZK_PATH   string
-	PROP_PATH        string
-	PATH_FOREVER     string
-	ip               string
-	port             string
-	connectionString string
-	zkUser           string
-	zkPwd            string
-	leafName         string
-	lastUpdateTime   int64
-	zkConn           *zk.Conn
-)
+// zookeeper方式获取workerId，实现 snowflake.WorkerIdProvider
+// 每个 ip:port 在 /snowflake/{leafName}/forever 下对应一个永久顺序节点，节点序号对 maxWorkerId+1 取模即workerId
+// 已停止的节点可通过 Admin 回收，之后新建的节点复用其workerId
+// 节点中记录上报的时间戳，重启时检查时钟是否回拨
+// workerId和时间戳同时缓存在本地文件中，连不上zk时使用缓存的workerId启动，并在后台重试注册
+// 会话过期后重新连接并认证，确认节点仍属于本 ip:port 且时钟正常之前处于不健康状态，snowflake不再生成id
+type WorkerIdProvider struct {
+	conf    config.Zookeeper
+	twepoch int64
+
+	ip            string
+	listenAddress string
+	foreverPath   string
+	propPath      string // 本地缓存的workerId文件
+
+	lock           sync.Mutex
+	maxWorkerId    int64
+	conn           *zk.Conn // 使用本地缓存启动且尚未注册成功时为nil
+	node           string
+	workerId       int64
+	lastUpdateTime int64
+	confirmTime    int64         // 最后一次在zk中确认节点属于本 ip:port 的时间
+	stop           chan struct{} // 停止后台注册和会话监听
+	wg             sync.WaitGroup
+	health         atomic.Value // healthState
+	peerClock      PeerClockCheck
+}
+
+type healthState struct {
+	err error
+}
 
-/**
-zookeeper方式获取workid
-*/
-func NewSnowflakeZookeeper(zconf *config.Zookeeper) service.IdGenerator {
-	ip = getIp()
-	port = zconf.Port
-	listenAddress = ip + ":" + zconf.Port
-	connectionString = zconf.Address
-	zkUser = zconf.User
-	zkPwd = zconf.Pwd
-	leafName = zconf.LeafName
-	configPath()
-	initZookeeper()
-
-	conf := snowflake.Config{
-		Twepoch: 0,
-		WorkerIdGetter: func() int64 {
-			return workID
-		},
+var errSessionExpired = errors.New("zookeeper session expired, revalidating workerId")
+
+// twepoch <= 0 时使用 snowflake.DefaultTwepoch
+func NewWorkerIdProvider(zconf *config.Zookeeper, twepoch int64) *WorkerIdProvider {
+	if twepoch <= 0 {
+		twepoch = snowflake.DefaultTwepoch
+	}
+	ip := util.LocalIp()
+	return &WorkerIdProvider{
+		conf:          *zconf,
+		twepoch:       twepoch,
+		ip:            ip,
+		listenAddress: ip + ":" + zconf.Port,
+		foreverPath:   "/snowflake/" + zconf.LeafName + "/forever",
+		propPath: filepath.Join(os.TempDir(), zconf.LeafName, "leafconf", zconf.Port,
+			"workerID.properties"),
+		workerId: -1,
 	}
+}
+
+// zookeeper方式获取workerId的snowflake生成器
+func NewSnowflakeZookeeper(zconf *config.Zookeeper, conf snowflake.Config) service.IdGenerator {
+	conf.WorkerIdProvider = NewWorkerIdProvider(zconf, conf.Twepoch)
 	return snowflake.New(conf)
 }
 
-func configPath() {
-	PREFIX_ZK_PATH = "/snowflake/" + leafName
-	PROP_PATH = os.TempDir() + string(filepath.Separator) + leafName + "/leafconf/" + port + "/workerID.properties"
-	PATH_FOREVER = PREFIX_ZK_PATH + "/forever"
+// 连接zk并获取workerId，连接失败时使用本地缓存的workerId
+func (p *WorkerIdProvider) Acquire(maxWorkerId int64) (int64, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.workerId >= 0 {
+		return -1, errors.New("workerId already acquired")
+	}
+	p.maxWorkerId = maxWorkerId
+	p.stop = make(chan struct{})
+	conn, events, err := p.connect()
+	if err != nil {
+		return p.acquireLocal(err)
+	}
+	if p.peerClock, err = p.checkPeerClock(conn); err != nil {
+		conn.Close()
+		return -1, err
+	}
+	node, workerId, err := p.register(conn, true)
+	if err != nil {
+		conn.Close()
+		return -1, err
+	}
+	// 写入当前时间，其它节点启动时据此检查时钟偏差
+	if err = p.writeTimestamp(conn, node); err != nil {
+		conn.Close()
+		return -1, err
+	}
+	p.conn, p.node, p.workerId = conn, node, workerId
+	p.confirmTime = curMilliseconds()
+	p.wg.Add(1)
+	go p.watch(conn, events, p.stop)
+	if err := p.saveLocal(curMilliseconds()); err != nil { // 不影响启动
+		log.Print("[zookeeper] save local workerId failed. err:%v", err)
+	}
+	return workerId, nil
 }
 
-func initZookeeper() {
-	// 创建zk连接地址
-	hosts := strings.Split(connectionString, ",")
-	// 连接zk
-	conn, _, err := zk.Connect(hosts, time.Second*6)
-	zkConn = conn
-	//defer conn.Close()
+// 时钟回拨检查与zk方式一致
+// 超过最短回收时间未在zk中确认时，workerId可能已被 Admin 回收并分配给其它节点，拒绝启动
+func (p *WorkerIdProvider) acquireLocal(connErr error) (int64, error) {
+	workerId, ts, confirmed, twepoch, err := p.loadLocal()
 	if err != nil {
-		fmt.Println(err)
-		return
+		return -1, fmt.Errorf("connect to zookeeper failed and no local workerId. err:%v, local err:%v", connErr, err)
+	}
+	now := curMilliseconds()
+	if ts > now {
+		return -1, &snowflake.ClockBackwardsError{Last: ts, Now: now}
 	}
-	zkConn.AddAuth("digest", []byte(zkUser+":"+zkPwd))
-	exist, _, err := zkConn.Exists(PATH_FOREVER)
+	if maxAge := int64(minReclaimDays * 24 * time.Hour / time.Millisecond); now-confirmed >= maxAge {
+		return -1, fmt.Errorf("connect to zookeeper failed and local workerId %d expired, last confirmed:%s. err:%v",
+			workerId, time.Unix(0, confirmed*1e6).Format(time.RFC3339), connErr)
+	}
+	if twepoch != 0 && twepoch != p.twepoch {
+		return -1, fmt.Errorf("twepoch mismatch, cached:%d, configured:%d", twepoch, p.twepoch)
+	}
+	log.Print("[zookeeper] connect failed, start with local workerId:%d. err:%v", workerId, connErr)
+	p.peerClock = PeerClockCheck{Skipped: "zookeeper unreachable"}
+	p.workerId = workerId
+	p.lastUpdateTime = ts
+	p.confirmTime = confirmed
+	p.wg.Add(1)
+	go p.retryRegister(p.stop)
+	return workerId, nil
+}
+
+func (p *WorkerIdProvider) retryRegister(stop chan struct{}) {
+	defer p.wg.Done()
+	tick := time.NewTicker(registerRetryInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+			if p.tryRegister(stop) {
+				return
+			}
+		}
+	}
+}
+
+// 返回是否结束重试
+// zk中没有本节点时不再创建，否则会分配到与本地缓存不同的workerId
+// 此时本地缓存的workerId可能已被回收并分配给其它节点，进入不健康状态
+func (p *WorkerIdProvider) tryRegister(stop chan struct{}) bool {
+	conn, events, err := p.connect()
 	if err != nil {
-		log.Println("检测节点错误")
-		return
+		log.Print("[zookeeper] retry register failed. err:%v", err)
+		return false
 	}
-	if exist {
-		keys, _, err := zkConn.Children(PATH_FOREVER)
-		if err != nil {
-			log.Println("获取子节点错误")
+	node, workerId, err := p.register(conn, false)
+	if err != nil {
+		conn.Close()
+		log.Print("[zookeeper] retry register failed. err:%v", err)
+		return false
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if node == "" || workerId != p.workerId {
+		conn.Close()
+		err := fmt.Errorf("node of endpoint %s in zookeeper:%q does not match local workerId %d",
+			p.listenAddress, node, p.workerId)
+		p.setHealth(err)
+		log.Print("[zookeeper] %v, stop issuing ids", err)
+		return true
+	}
+	p.conn, p.node = conn, node
+	p.confirmTime = curMilliseconds()
+	p.wg.Add(1)
+	go p.watch(conn, events, stop)
+	log.Print("[zookeeper] register to zookeeper succeeded, workerId:%d", workerId)
+	return true
+}
+
+// 监听会话事件，会话过期后关闭连接，重新连接并认证，然后重新验证节点
+// 连接关闭或停止时退出
+func (p *WorkerIdProvider) watch(conn *zk.Conn, events <-chan zk.Event, stop chan struct{}) {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-stop:
 			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if e.State != zk.StateExpired {
+				continue
+			}
 		}
-		nodeMap := make(map[string]int64)
-		realNode := make(map[string]string)
-		for _, s := range keys {
-			nodeKey := strings.Split(s, "-")
-			realNode[nodeKey[0]] = s
-			nodeMap[nodeKey[0]], _ = strconv.ParseInt(nodeKey[1], 10, 64)
+
+		log.Print("[zookeeper] session expired, reconnecting")
+		p.setHealth(errSessionExpired)
+		p.lock.Lock()
+		if p.conn == conn {
+			p.conn = nil
 		}
-		workerID, ok := nodeMap[listenAddress]
-		if ok {
-			zkAddressNode = PATH_FOREVER + "/" + realNode[listenAddress]
-			workID = workerID
-			//判断时钟回拨
-			timeRight, err := checkInitTimeStamp(zkAddressNode)
-			if !timeRight || err != nil {
-				log.Println("时钟回拨异常")
-				return
+		p.lock.Unlock()
+		conn.Close()
+		if conn, events = p.reconnect(stop); conn == nil {
+			return
+		}
+	}
+}
+
+// 重试直到重新验证通过，停止或节点已不属于本 ip:port 时返回nil
+func (p *WorkerIdProvider) reconnect(stop chan struct{}) (*zk.Conn, <-chan zk.Event) {
+	for {
+		conn, events, err := p.connect()
+		if err == nil {
+			if err = p.revalidate(conn); err == nil {
+				p.lock.Lock()
+				p.conn = conn
+				p.lock.Unlock()
+				p.setHealth(nil)
+				log.Print("[zookeeper] reconnected and revalidated workerId:%d", p.workerId)
+				return conn, events
 			}
-			ScheduledUploadData(zkAddressNode)
-			updateLocalWorkerID(workID)
-			log.Println("[Old NODE]find forever node have this endpoint ip-{} port-{} workid-{} childnode and start SUCCESS", ip, port, workID)
-		} else {
-			newNode, err := createNode(zkConn)
-			if err != nil {
-				return
+			conn.Close()
+			if _, ok := err.(*ownershipError); ok {
+				p.setHealth(err)
+				log.Print("[zookeeper] %v, stop issuing ids", err)
+				return nil, nil
 			}
-			zkAddressNode = newNode
-			nodeKey := strings.Split(newNode, "-")
-			workID, _ = strconv.ParseInt(nodeKey[1], 10, 64)
-			ScheduledUploadData(zkAddressNode)
-			updateLocalWorkerID(workID)
-			log.Println("[New NODE]can not find node on forever node that endpoint ip-{} port-{} workid-{},create own node on forever node and start SUCCESS ", ip, port, workerID)
 		}
+		log.Print("[zookeeper] revalidate workerId failed, retry later. err:%v", err)
+
+		select {
+		case <-stop:
+			return nil, nil
+		case <-time.After(registerRetryInterval):
+		}
+	}
+}
+
+// 节点不存在或已不属于本 ip:port
+type ownershipError struct {
+	node string
+	ip   string
+	port string
+}
+
+func (e *ownershipError) Error() string {
+	return fmt.Sprintf("node %s no longer belongs to %s:%s", e.node, e.ip, e.port)
+}
+
+// 确认节点仍属于本 ip:port，且节点中的时间戳不晚于当前时间
+func (p *WorkerIdProvider) revalidate(conn *zk.Conn) error {
+	_, err := p.checkOwner(conn)
+	if err != nil {
+		return err
+	}
+	return p.checkNode(conn, p.node)
+}
+
+// 返回节点的版本号
+func (p *WorkerIdProvider) checkOwner(conn *zk.Conn) (int32, error) {
+	data, stat, err := conn.Get(p.node)
+	if err == zk.ErrNoNode {
+		return 0, &ownershipError{p.node, p.ip, p.conf.Port}
+	}
+	if err != nil {
+		return 0, err
+	}
+	endpoint, err := Decode(data)
+	if err != nil || endpoint.Ip != p.ip || endpoint.Port != p.conf.Port {
+		return 0, &ownershipError{p.node, p.ip, p.conf.Port}
+	}
+	return stat.Version, nil
+}
+
+// 健康时返回nil，实现 snowflake.HealthChecker
+func (p *WorkerIdProvider) Healthy() error {
+	if h, ok := p.health.Load().(healthState); ok {
+		return h.err
+	}
+	return nil
+}
+
+func (p *WorkerIdProvider) HealthDetails() map[string]interface{} {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return map[string]interface{}{
+		"worker_id_provider":  "zookeeper",
+		"zookeeper_node":      p.node,
+		"zookeeper_connected": p.conn != nil,
+		"peer_clock_check":    p.peerClock,
+	}
+}
+
+func (p *WorkerIdProvider) setHealth(err error) {
+	p.health.Store(healthState{err})
+}
+
+// 查找或创建(create为true时)本节点，并检查时钟回拨和twepoch
+func (p *WorkerIdProvider) register(conn *zk.Conn, create bool) (string, int64, error) {
+	if err := ensurePath(conn, p.foreverPath); err != nil {
+		return "", -1, fmt.Errorf("create path %s failed. err:%v", p.foreverPath, err)
+	}
+
+	node, err := p.findNode(conn)
+	if err != nil {
+		return "", -1, err
+	}
+	if node == "" {
+		if !create {
+			return "", -1, nil
+		}
+		return p.createNode(conn)
+	}
+	// 时钟回拨检查，且twepoch必须与注册时一致，否则生成的id可能与之前的重复
+	if err = p.checkNode(conn, node); err != nil {
+		return "", -1, err
+	}
+	workerId, err := p.nodeWorkerId(node)
+	if err != nil {
+		return "", -1, err
+	}
+	keys, err := p.children(conn)
+	if err != nil {
+		return "", -1, err
+	}
+	if other := p.conflict(keys, node, workerId); other != "" {
+		return "", -1, fmt.Errorf("workerId %d of node %s is used by node %s, reclaim dead workers first", workerId, node, other)
+	}
+	log.Print("[zookeeper] find forever node of endpoint %s, node:%s", p.listenAddress, node)
+	return node, workerId, nil
+}
+
+// 节点是永久节点，无需续约，会话由zk客户端维持
+func (p *WorkerIdProvider) Renew() error {
+	return nil
+}
 
-	} else {
-		zkAddressNode, err := createNode(zkConn)
+// 写入本地缓存和节点，系统时钟回拨时跳过
+// 写入前确认节点仍属于本 ip:port，否则进入不健康状态
+func (p *WorkerIdProvider) ReportTimestamp(ts int64) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.workerId < 0 {
+		return errors.New("workerId not acquired")
+	}
+	// 记录当前时间，其它节点启动时据此检查时钟偏差。已使用的时间戳可能领先于当前时间(见 snowflake.ClockPolicyBorrow)
+	if now := curMilliseconds(); now > ts {
+		ts = now
+	}
+	if ts < p.lastUpdateTime {
+		return nil
+	}
+	if err := p.saveLocal(ts); err != nil {
+		log.Print("[zookeeper] save local workerId failed. err:%v", err)
+	}
+	if p.conn != nil {
+		version, err := p.checkOwner(p.conn)
 		if err != nil {
-			fmt.Println("创建节点错误")
-			return
+			if _, ok := err.(*ownershipError); ok {
+				p.setHealth(err)
+			}
+			return err
 		}
-		updateLocalWorkerID(workID)
-		ScheduledUploadData(zkAddressNode)
+		p.confirmTime = curMilliseconds()
+		data, err := (&Endpoint{p.ip, p.conf.Port, ts, p.twepoch}).Encode()
+		if err != nil {
+			return err
+		}
+		if _, err = p.conn.Set(p.node, data, version); err != nil {
+			return err
+		}
+	}
+	p.lastUpdateTime = ts
+	return nil
+}
+
+// 停止后台注册并关闭zk连接，节点保留，重启后仍使用同一个workerId
+func (p *WorkerIdProvider) Release() error {
+	p.lock.Lock()
+	stop := p.stop
+	p.stop = nil
+	p.lock.Unlock()
+	if stop != nil {
+		close(stop)
+		p.wg.Wait()
 	}
 
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	p.workerId = -1
+	return nil
+}
+
+func (p *WorkerIdProvider) connect() (*zk.Conn, <-chan zk.Event, error) {
+	return connect(&p.conf)
 }
 
-func createNode(conn *zk.Conn) (string, error) {
-	// 开始监听path
-	var exist, _, err = conn.Exists(PATH_FOREVER + "/" + listenAddress + "-")
+// 连接并认证，返回的事件通道可以不读取
+func connect(conf *config.Zookeeper) (*zk.Conn, <-chan zk.Event, error) {
+	conn, events, err := zk.Connect(strings.Split(conf.Address, ","), sessionTimeout,
+		zk.WithLogger(zkLogger{}), zk.WithLogInfo(false))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	timeout := time.NewTimer(connectTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case e := <-events:
+			if e.State == zk.StateHasSession {
+				if conf.User != "" {
+					if err := conn.AddAuth("digest", []byte(conf.User+":"+conf.Pwd)); err != nil {
+						conn.Close()
+						return nil, nil, err
+					}
+				}
+				return conn, events, nil
+			}
+		case <-timeout.C:
+			conn.Close()
+			return nil, nil, fmt.Errorf("connect to zookeeper %s timeout", conf.Address)
+		}
+	}
+}
+
+// 逐级创建永久节点
+func ensurePath(conn *zk.Conn, path string) error {
+	cur := ""
+	for _, part := range strings.Split(strings.Trim(path, "/"), "/") {
+		cur += "/" + part
+		_, err := conn.Create(cur, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *WorkerIdProvider) children(conn *zk.Conn) ([]string, error) {
+	keys, _, err := conn.Children(p.foreverPath)
+	if err != nil {
+		return nil, fmt.Errorf("get children of %s failed. err:%v", p.foreverPath, err)
+	}
+	return keys, nil
+}
+
+// 查找当前 ip:port 已注册的节点，不存在时返回空
+func (p *WorkerIdProvider) findNode(conn *zk.Conn) (string, error) {
+	keys, err := p.children(conn)
 	if err != nil {
-		fmt.Println(err)
 		return "", err
 	}
-	if !exist {
-		acls := zk.WorldACL(zk.PermAll)
-		var data, err = buildData()
+	for _, key := range keys {
+		if strings.HasPrefix(key, p.listenAddress+"-") {
+			return p.foreverPath + "/" + key, nil
+		}
+	}
+	return "", nil
+}
+
+// 创建节点，workerId与其它节点冲突时删除后重新创建，序号递增，直到取模后落在空闲的workerId上
+// 并发创建时序号小的节点优先
+func (p *WorkerIdProvider) createNode(conn *zk.Conn) (string, int64, error) {
+	data, err := p.buildData()
+	if err != nil {
+		return "", -1, err
+	}
+	for i := int64(0); i <= p.maxWorkerId; i++ {
+		keys, err := p.children(conn)
+		if err != nil {
+			return "", -1, err
+		}
+		if int64(len(p.usedWorkerIds(keys))) > p.maxWorkerId {
+			return "", -1, fmt.Errorf("no free workerId in [0, %d], reclaim dead workers first", p.maxWorkerId)
+		}
+		node, err := conn.Create(p.foreverPath+"/"+p.listenAddress+"-", data, zk.FlagSequence,
+			zk.WorldACL(zk.PermAll))
 		if err != nil {
-			fmt.Printf("创建失败: %v\n", err)
-			return "", err
+			return "", -1, fmt.Errorf("create node failed. err:%v", err)
 		}
-		// flags有4种取值：
-		// 0:永久，除非手动删除
-		// zk.FlagEphemeral = 1:短暂，session断开则该节点也被删除
-		// zk.FlagSequence  = 2:会自动在节点后面添加序号
-		// 3:Ephemeral和Sequence，即，短暂且自动添加序号
-		var flags int32 = 2
-		s, err := conn.Create(PATH_FOREVER+"/"+listenAddress+"-", data, flags, acls)
+		workerId, err := p.nodeWorkerId(node)
 		if err != nil {
-			fmt.Printf("创建失败: %v\n", err)
-			return "", err
+			return "", -1, err
+		}
+		if keys, err = p.children(conn); err != nil {
+			return "", -1, err
+		}
+		if p.conflict(keys, node, workerId) == "" {
+			log.Print("[zookeeper] create forever node of endpoint %s, node:%s", p.listenAddress, node)
+			return node, workerId, nil
+		}
+		if err = conn.Delete(node, -1); err != nil {
+			return "", -1, fmt.Errorf("delete conflicting node %s failed. err:%v", node, err)
 		}
-		return s, nil
 	}
-	return "", nil
+	return "", -1, fmt.Errorf("no free workerId in [0, %d], reclaim dead workers first", p.maxWorkerId)
+}
+
+// 返回workerId相同且序号小于node的节点，没有时返回空
+func (p *WorkerIdProvider) conflict(keys []string, node string, workerId int64) string {
+	seq, _ := parseSequence(node)
+	for _, key := range keys {
+		other, err := parseSequence(key)
+		if err != nil || other >= seq || other%(p.maxWorkerId+1) != workerId {
+			continue
+		}
+		return p.foreverPath + "/" + key
+	}
+	return ""
 }
 
-func buildData() ([]byte, error) {
-	endpoint := &Endpoint{ip, port, time.Now().UnixNano() / 1e6}
-	var data, err = endpoint.Encode()
+func (p *WorkerIdProvider) usedWorkerIds(keys []string) map[int64]bool {
+	used := make(map[int64]bool, len(keys))
+	for _, key := range keys {
+		if workerId, err := p.nodeWorkerId(key); err == nil {
+			used[workerId] = true
+		}
+	}
+	return used
+}
+
+func (p *WorkerIdProvider) nodeWorkerId(node string) (int64, error) {
+	seq, err := parseSequence(node)
 	if err != nil {
-		return nil, err
+		return -1, err
 	}
-	return data, nil
+	return seq % (p.maxWorkerId + 1), nil
 }
 
-func checkInitTimeStamp(zkAddressNode string) (bool, error) {
-	bytes, _, err := zkConn.Get(zkAddressNode)
+func (p *WorkerIdProvider) checkNode(conn *zk.Conn, node string) error {
+	data, _, err := conn.Get(node)
 	if err != nil {
-		return false, err
+		return err
+	}
+	endpoint, err := Decode(data)
+	if err != nil {
+		return err
+	}
+	if now := curMilliseconds(); endpoint.Timestamp > now {
+		return &snowflake.ClockBackwardsError{Last: endpoint.Timestamp, Now: now}
+	}
+	if endpoint.Twepoch != 0 && endpoint.Twepoch != p.twepoch { // 旧版本未记录twepoch，在下次上报时写入
+		return fmt.Errorf("twepoch mismatch, registered:%d, configured:%d", endpoint.Twepoch, p.twepoch)
 	}
-	endPoint, err := Decode(bytes)
+	return nil
+}
+
+// 顺序节点的序号
+func parseSequence(node string) (int64, error) {
+	i := strings.LastIndex(node, "-")
+	if i < 0 {
+		return -1, fmt.Errorf("invalid node:%s", node)
+	}
+	seq, err := strconv.ParseInt(node[i+1:], 10, 64)
 	if err != nil {
-		return false, err
+		return -1, fmt.Errorf("invalid node:%s", node)
 	}
-	return !(endPoint.Timestamp > (time.Now().UnixNano() / 1e6)), nil
+	return seq, nil
 }
 
-/**
-* 在节点文件系统上缓存一个workid值,zk失效,机器重启时保证能够正常启动
-*
-* @param workerID
- */
-func updateLocalWorkerID(workerID int64) error {
-	f, err := os.OpenFile(PROP_PATH, os.O_CREATE|os.O_RDWR|os.O_TRUNC, os.ModeExclusive|os.ModePerm)
+func (p *WorkerIdProvider) writeTimestamp(conn *zk.Conn, node string) error {
+	data, err := p.buildData()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	_, err = conn.Set(node, data, -1)
+	return err
+}
 
-	f.WriteString("workerID=" + strconv.FormatInt(workerID, 10))
-	return nil
+func (p *WorkerIdProvider) buildData() ([]byte, error) {
+	endpoint := &Endpoint{p.ip, p.conf.Port, curMilliseconds(), p.twepoch}
+	return endpoint.Encode()
 }
 
-func ScheduledUploadData(zkAddressNode string) {
-	ticker := time.NewTicker(time.Second * 3)
-	go func() {
-		for _ = range ticker.C {
-			if time.Now().UnixNano()/1e6 < lastUpdateTime {
-				return
-			}
-			var data, err = buildData()
-			if err != nil {
-				fmt.Printf("创建失败: %v\n", err)
-				continue
-			}
-			_, stat, err := zkConn.Get(zkAddressNode)
-			if err != nil {
-				fmt.Println("获取zk数据失败", err)
-				continue
-			}
-			_, err = zkConn.Set(zkAddressNode, data, stat.Version)
-			if err != nil {
-				fmt.Printf("数据修改失败: %v\n", err)
-				continue
-			}
+// 在节点文件系统上缓存workerId和时间戳，zk失效、机器重启时保证能够正常启动
+func (p *WorkerIdProvider) saveLocal(ts int64) error {
+	if err := os.MkdirAll(filepath.Dir(p.propPath), os.ModePerm); err != nil {
+		return err
+	}
+	data := fmt.Sprintf("workerID=%d\ntimestamp=%d\nconfirmed=%d\ntwepoch=%d\n", p.workerId, ts, p.confirmTime,
+		p.twepoch)
+	tmp := p.propPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(data), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.propPath)
+}
+
+// 旧版本只记录了workerID，此时时间戳和twepoch为0。未记录确认时间时使用时间戳
+func (p *WorkerIdProvider) loadLocal() (workerId, ts, confirmed, twepoch int64, err error) {
+	data, err := os.ReadFile(p.propPath)
+	if err != nil {
+		return -1, 0, 0, 0, err
+	}
+	confirmed = -1
+	workerId = -1
+	for _, line := range strings.Split(string(data), "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v, err := strconv.ParseInt(strings.TrimSpace(kv[1]), 10, 64)
+		if err != nil {
+			return -1, 0, 0, 0, fmt.Errorf("invalid line %q in %s", line, p.propPath)
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "workerID":
+			workerId = v
+		case "timestamp":
+			ts = v
+		case "confirmed":
+			confirmed = v
+		case "twepoch":
+			twepoch = v
 		}
-	}()
+	}
+	if workerId < 0 {
+		return -1, 0, 0, 0, fmt.Errorf("no workerID in %s", p.propPath)
+	}
+	if confirmed < 0 {
+		confirmed = ts
+	}
+	return workerId, ts, confirmed, twepoch, nil
 }
 
 func (obj *Endpoint) Encode() ([]byte, error) {
-	//buf := new(bytes.Buffer)
-	//
-	//if err := binary.Write(buf, binary.LittleEndian, obj); err != nil {
-	//	return nil, err
-	//}
-	//
-	//return buf.Bytes(), nil
-	var buf []byte
-	var err error
-
-	if buf, err = json.Marshal(obj); err != nil {
-		log.Fatal("json marshal error:", err)
-		return nil, err
-	}
-	return buf, nil
+	return json.Marshal(obj)
 }
 
 func Decode(b []byte) (*Endpoint, error) {
-
 	obj := &Endpoint{}
-	var err error
-	if err = json.Unmarshal(b, obj); err != nil {
+	if err := json.Unmarshal(b, obj); err != nil {
 		return nil, err
 	}
 	return obj, nil
 }
 
-func getIp() string {
-	addrs, err := net.InterfaceAddrs()
-	if err != nil {
-		fmt.Println(err)
-		return ""
-	}
-	for _, value := range addrs {
-		if ipnet, ok := value.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if ipnet.IP.To4() != nil {
-				return ipnet.IP.String()
-			}
-		}
-	}
-	return ""
+type zkLogger struct{}
+
+func (zkLogger) Printf(format string, args ...interface{}) {
+	log.Print("[zookeeper] "+format, args...)
 }
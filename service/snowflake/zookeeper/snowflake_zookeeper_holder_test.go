@@ -0,0 +1,405 @@
+package zookeeper
+
+import (
+	"errors"
+	"github.com/longyufei109/leaf-go/config"
+	"github.com/longyufei109/leaf-go/service/snowflake"
+	"github.com/longyufei109/leaf-go/service/snowflake/zookeeper/zktest"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) *zktest.Server {
+	t.Setenv("TMPDIR", t.TempDir()) // workerID.properties 写入临时目录
+	s, err := zktest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestProvider(s *zktest.Server, port string) *WorkerIdProvider {
+	return NewWorkerIdProvider(&config.Zookeeper{LeafName: "test", Address: s.Addr(), Port: port}, 0)
+}
+
+func TestWorkerIdProvider_MultiInstance(t *testing.T) {
+	s := newTestServer(t)
+
+	ids := map[int64]string{}
+	for _, port := range []string{"8001", "8002", "8003"} {
+		p := newTestProvider(s, port)
+		id, err := p.Acquire(1023)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other, ok := ids[id]; ok {
+			t.Fatalf("workerId %d is used by both %s and %s", id, other, port)
+		}
+		ids[id] = port
+		if err = p.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 重启后使用同一个workerId
+	p := newTestProvider(s, "8002")
+	id, err := p.Acquire(1023)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	if ids[id] != "8002" {
+		t.Fatalf("restarted with workerId %d, want the one of 8002", id)
+	}
+	if _, err = p.Acquire(1023); err == nil {
+		t.Fatal("expect already acquired error")
+	}
+}
+
+func TestWorkerIdProvider_CheckNode(t *testing.T) {
+	s := newTestServer(t)
+	p := newTestProvider(s, "8001")
+	if _, err := p.Acquire(1023); err != nil {
+		t.Fatal(err)
+	}
+	// 上报一个未来的时间戳，模拟时钟回拨后重启
+	if err := p.ReportTimestamp(time.Now().UnixNano()/1e6 + 60000); err != nil {
+		t.Fatal(err)
+	}
+	_ = p.Release()
+	var clockErr *snowflake.ClockBackwardsError
+	if _, err := newTestProvider(s, "8001").Acquire(1023); !errors.As(err, &clockErr) {
+		t.Fatalf("expect clock backwards error, got %v", err)
+	}
+
+	// twepoch不一致
+	old, _ := (&Endpoint{p.ip, "8001", time.Now().UnixNano() / 1e6, p.twepoch - 1}).Encode()
+	if err := s.Set(p.node, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTestProvider(s, "8001").Acquire(1023); err == nil {
+		t.Fatal("expect twepoch mismatch error")
+	}
+}
+
+func TestWorkerIdProvider_Unavailable(t *testing.T) {
+	originConnect := connectTimeout
+	connectTimeout = 200 * time.Millisecond
+	defer func() { connectTimeout = originConnect }()
+
+	s := newTestServer(t)
+	s.Stop()
+	p := newTestProvider(s, "8001") // 没有本地缓存
+	if _, err := p.Acquire(1023); err == nil {
+		t.Fatal("expect connect error")
+	}
+	if err := p.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewSnowflakeZookeeper(t *testing.T) {
+	s := newTestServer(t)
+	g := NewSnowflakeZookeeper(&config.Zookeeper{LeafName: "test", Address: s.Addr(), Port: "8001"},
+		snowflake.Config{RenewInterval: 100 * time.Millisecond})
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	id, err := g.Gen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	g.Shutdown()
+
+	// 退出时上报了最后使用的时间戳
+	node := "/snowflake/test/forever/" + NewWorkerIdProvider(&config.Zookeeper{Port: "8001"}, 0).listenAddress +
+		"-0000000000"
+	data, ok := s.Get(node)
+	if !ok {
+		t.Fatalf("node %s not found", node)
+	}
+	endpoint, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := snowflake.Decode(id, 0); endpoint.Timestamp < info.Timestamp {
+		t.Fatalf("reported timestamp %d is before the id's %d", endpoint.Timestamp, info.Timestamp)
+	}
+}
+
+func TestWorkerIdProvider_LocalFallback(t *testing.T) {
+	originConnect, originRetry := connectTimeout, registerRetryInterval
+	connectTimeout, registerRetryInterval = 200*time.Millisecond, 50*time.Millisecond
+	defer func() { connectTimeout, registerRetryInterval = originConnect, originRetry }()
+
+	s := newTestServer(t)
+	p := newTestProvider(s, "8001")
+	id, err := p.Acquire(1023)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = p.Release()
+
+	// zk不可用时使用本地缓存的workerId
+	s.Stop()
+	p = newTestProvider(s, "8001")
+	if cached, err := p.Acquire(1023); err != nil || cached != id {
+		t.Fatalf("expect local workerId %d, got %d, err:%v", id, cached, err)
+	}
+	defer p.Release()
+	if err = p.ReportTimestamp(time.Now().UnixNano() / 1e6); err != nil {
+		t.Fatal(err)
+	}
+
+	// zk恢复后在后台注册
+	if err = s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.lock.Lock()
+		registered := p.conn != nil
+		p.lock.Unlock()
+		if registered {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("not registered after zookeeper recovered")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	ts := time.Now().UnixNano()/1e6 + 1000
+	if err = p.ReportTimestamp(ts); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := s.Get(p.node)
+	if endpoint, err := Decode(data); err != nil || endpoint.Timestamp != ts {
+		t.Fatalf("expect reported timestamp %d, got %s, err:%v", ts, data, err)
+	}
+}
+
+func TestWorkerIdProvider_LocalClockBackwards(t *testing.T) {
+	originConnect := connectTimeout
+	connectTimeout = 200 * time.Millisecond
+	defer func() { connectTimeout = originConnect }()
+
+	s := newTestServer(t)
+	p := newTestProvider(s, "8001")
+	if _, err := p.Acquire(1023); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ReportTimestamp(time.Now().UnixNano()/1e6 + 60000); err != nil {
+		t.Fatal(err)
+	}
+	_ = p.Release()
+
+	s.Stop()
+	var clockErr *snowflake.ClockBackwardsError
+	if _, err := newTestProvider(s, "8001").Acquire(1023); !errors.As(err, &clockErr) {
+		t.Fatalf("expect clock backwards error, got %v", err)
+	}
+}
+
+// 超过最短回收时间未在zk中确认的本地缓存不再使用，使用缓存期间上报时间戳不延长有效期
+func TestWorkerIdProvider_LocalExpired(t *testing.T) {
+	originConnect := connectTimeout
+	connectTimeout = 200 * time.Millisecond
+	defer func() { connectTimeout = originConnect }()
+
+	s := newTestServer(t)
+	p := newTestProvider(s, "8001")
+	if _, err := p.Acquire(1023); err != nil {
+		t.Fatal(err)
+	}
+	_ = p.Release()
+	s.Stop()
+
+	defer func(f func() int64) { curMilliseconds = f }(curMilliseconds)
+	start := time.Now().UnixNano() / 1e6
+	curMilliseconds = func() int64 { return start + 3600*1000 }
+	p = newTestProvider(s, "8001")
+	if _, err := p.Acquire(1023); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ReportTimestamp(curMilliseconds()); err != nil {
+		t.Fatal(err)
+	}
+	_ = p.Release()
+
+	curMilliseconds = func() int64 { return start + minReclaimDays*24*3600*1000 }
+	if _, err := newTestProvider(s, "8001").Acquire(1023); err == nil {
+		t.Fatal("expect local workerId expired")
+	}
+}
+
+func waitHealth(t *testing.T, p *WorkerIdProvider, healthy bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for (p.Healthy() == nil) != healthy {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect healthy:%v, err:%v", healthy, p.Healthy())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestWorkerIdProvider_SessionExpired(t *testing.T) {
+	originRetry := registerRetryInterval
+	registerRetryInterval = 50 * time.Millisecond
+	defer func() { registerRetryInterval = originRetry }()
+
+	s := newTestServer(t)
+	p := newTestProvider(s, "8001")
+	if _, err := p.Acquire(1023); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	p.lock.Lock()
+	conn := p.conn
+	p.lock.Unlock()
+
+	// 会话过期后重新连接，节点仍属于本节点
+	s.ExpireSessions()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.lock.Lock()
+		reconnected := p.conn != nil && p.conn != conn
+		p.lock.Unlock()
+		if reconnected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("not reconnected after session expired")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	waitHealth(t, p, true)
+	if err := p.ReportTimestamp(time.Now().UnixNano() / 1e6); err != nil {
+		t.Fatal(err)
+	}
+
+	// 会话过期期间节点被其它endpoint占用
+	other, _ := (&Endpoint{"10.0.0.1", "8001", time.Now().UnixNano() / 1e6, p.twepoch}).Encode()
+	if err := s.Set(p.node, other); err != nil {
+		t.Fatal(err)
+	}
+	s.ExpireSessions()
+	waitHealth(t, p, false)
+	time.Sleep(200 * time.Millisecond) // 不会恢复
+	if _, ok := p.Healthy().(*ownershipError); !ok {
+		t.Fatalf("expect ownership error, got %v", p.Healthy())
+	}
+}
+
+func TestWorkerIdProvider_OwnershipLost(t *testing.T) {
+	s := newTestServer(t)
+	p := newTestProvider(s, "8001")
+	g := snowflake.New(snowflake.Config{WorkerIdProvider: p, RenewInterval: 50 * time.Millisecond})
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Shutdown()
+	if _, err := g.Gen(""); err != nil {
+		t.Fatal(err)
+	}
+
+	// 上报时间戳时发现节点已不属于本节点，停止生成id
+	other, _ := (&Endpoint{"10.0.0.1", "8001", time.Now().UnixNano() / 1e6, p.twepoch}).Encode()
+	if err := s.Set(p.node, other); err != nil {
+		t.Fatal(err)
+	}
+	waitHealth(t, p, false)
+	if _, err := g.Gen(""); err == nil {
+		t.Fatal("expect error after ownership lost")
+	}
+}
+
+func TestWorkerIdProvider_PeerClockSkew(t *testing.T) {
+	s := newTestServer(t)
+	peer := newTestProvider(s, "8001")
+	if _, err := peer.Acquire(1023); err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Release()
+
+	p := newTestProvider(s, "8002")
+	if _, err := p.Acquire(1023); err != nil {
+		t.Fatal(err)
+	}
+	check := p.HealthDetails()["peer_clock_check"].(PeerClockCheck)
+	if !check.Passed || check.Peers != 1 || check.MaxSkewMs != defaultMaxPeerClockSkew {
+		t.Fatalf("unexpected peer clock check:%+v", check)
+	}
+	_ = p.Release()
+
+	// 存活节点的时间比本机快10秒
+	skewed, _ := (&Endpoint{peer.ip, "8001", time.Now().UnixNano()/1e6 + 10000, peer.twepoch}).Encode()
+	if err := s.Set(peer.node, skewed); err != nil {
+		t.Fatal(err)
+	}
+	p = newTestProvider(s, "8002")
+	if _, err := p.Acquire(1023); err == nil {
+		p.Release()
+		t.Fatal("expect clock skew error")
+	}
+
+	// 调大阈值或关闭检查
+	for _, max := range []int64{20000, -1} {
+		p = NewWorkerIdProvider(&config.Zookeeper{LeafName: "test", Address: s.Addr(), Port: "8002", MaxPeerClockSkew: max}, 0)
+		if _, err := p.Acquire(1023); err != nil {
+			t.Fatalf("max skew:%d, err:%v", max, err)
+		}
+		check = p.HealthDetails()["peer_clock_check"].(PeerClockCheck)
+		if max > 0 && (!check.Passed || check.SkewMs > -9000) || max < 0 && check.Skipped == "" {
+			t.Fatalf("max skew:%d, unexpected peer clock check:%+v", max, check)
+		}
+		_ = p.Release()
+	}
+}
+
+// 本机时钟超前导致检查失败时不写入节点，时钟恢复后可以正常重启
+func TestWorkerIdProvider_PeerClockSkewRestart(t *testing.T) {
+	s := newTestServer(t)
+	peer := newTestProvider(s, "8001")
+	if _, err := peer.Acquire(1023); err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Release()
+	p := newTestProvider(s, "8002")
+	if _, err := p.Acquire(1023); err != nil {
+		t.Fatal(err)
+	}
+	node := p.node
+	_ = p.Release()
+	before, _ := s.Get(node)
+
+	defer func(f func() int64) { curMilliseconds = f }(curMilliseconds)
+	curMilliseconds = func() int64 { return time.Now().UnixNano()/1e6 + 60000 }
+	p = newTestProvider(s, "8002")
+	if _, err := p.Acquire(1023); err == nil {
+		p.Release()
+		t.Fatal("expect clock skew error")
+	}
+	if after, _ := s.Get(node); string(after) != string(before) {
+		t.Fatalf("node written after failed check:%s", after)
+	}
+
+	curMilliseconds = func() int64 { return time.Now().UnixNano() / 1e6 }
+	p = newTestProvider(s, "8002")
+	if _, err := p.Acquire(1023); err != nil {
+		t.Fatal(err)
+	}
+	_ = p.Release()
+
+	// 首次启动时检查失败不创建节点
+	curMilliseconds = func() int64 { return time.Now().UnixNano()/1e6 + 60000 }
+	p = newTestProvider(s, "8003")
+	if _, err := p.Acquire(1023); err == nil {
+		p.Release()
+		t.Fatal("expect clock skew error")
+	}
+	if keys, _ := peer.children(peer.conn); len(keys) != 2 {
+		t.Fatalf("unexpected nodes:%v", keys)
+	}
+}
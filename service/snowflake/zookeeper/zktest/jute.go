@@ -0,0 +1,118 @@
+package zktest
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// zookeeper使用的jute序列化格式: 大端整数，字符串/字节数组/数组均为 int32长度(-1 表示nil) + 内容
+
+var errShortBuffer = errors.New("zktest: buffer too small")
+
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.buf) < n {
+		d.err = errShortBuffer
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) int32() int32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) int64() int64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (d *decoder) bool() bool {
+	b := d.next(1)
+	return b != nil && b[0] != 0
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return append([]byte(nil), d.next(int(n))...)
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) acls() []zk.ACL {
+	n := d.int32()
+	var acls []zk.ACL
+	for i := int32(0); i < n && d.err == nil; i++ {
+		acls = append(acls, zk.ACL{Perms: d.int32(), Scheme: d.string(), ID: d.string()})
+	}
+	return acls
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) int32(v int32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v))
+}
+
+func (e *encoder) int64(v int64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v))
+}
+
+func (e *encoder) bytes(b []byte) {
+	if b == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) string(s string) {
+	e.int32(int32(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) strings(ss []string) {
+	e.int32(int32(len(ss)))
+	for _, s := range ss {
+		e.string(s)
+	}
+}
+
+func (e *encoder) stat(s *zk.Stat) {
+	e.int64(s.Czxid)
+	e.int64(s.Mzxid)
+	e.int64(s.Ctime)
+	e.int64(s.Mtime)
+	e.int32(s.Version)
+	e.int32(s.Cversion)
+	e.int32(s.Aversion)
+	e.int64(s.EphemeralOwner)
+	e.int32(s.DataLength)
+	e.int32(s.NumChildren)
+	e.int64(s.Pzxid)
+}
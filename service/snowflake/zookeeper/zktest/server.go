@@ -0,0 +1,440 @@
+// Package zktest 提供一个进程内的zookeeper服务端替身，实现了 go-zookeeper 客户端用到的协议子集，
+// 包括会话(建立、恢复、过期)、认证、节点的增删改查、临时节点和顺序节点，不支持watch通知，仅用于测试
+package zktest
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"github.com/samuel/go-zookeeper/zk"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	opCreate       = 1
+	opDelete       = 2
+	opExists       = 3
+	opGetData      = 4
+	opSetData      = 5
+	opGetChildren  = 8
+	opPing         = 11
+	opGetChildren2 = 12
+	opClose        = -11
+	opSetAuth      = 100
+	opSetWatches   = 101
+
+	errOk            = 0
+	errUnimplemented = -6
+	errNoNode        = -101
+	errBadVersion    = -103
+	errNoChildrenEph = -108
+	errNodeExists    = -110
+	errNotEmpty      = -111
+)
+
+type node struct {
+	data     []byte
+	stat     zk.Stat
+	children map[string]struct{}
+}
+
+type session struct {
+	id      int64
+	passwd  []byte
+	timeout int32
+	conn    net.Conn
+}
+
+type Server struct {
+	addr string
+
+	mu          sync.Mutex
+	ln          net.Listener
+	nodes       map[string]*node
+	sessions    map[int64]*session
+	conns       map[net.Conn]struct{}
+	lastSession int64
+	zxid        int64
+	wg          sync.WaitGroup
+}
+
+// 在 127.0.0.1 的随机端口上启动
+func NewServer() (*Server, error) {
+	s := &Server{
+		nodes:    map[string]*node{"/": {children: map[string]struct{}{}}},
+		sessions: map[int64]*session{},
+		conns:    map[net.Conn]struct{}{},
+	}
+	if err := s.listen("127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+	s.addr = s.ln.Addr().String()
+	return s, nil
+}
+
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+func (s *Server) listen(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go s.accept(ln)
+	return nil
+}
+
+// 模拟服务不可用：关闭监听和所有连接，保留数据和会话
+func (s *Server) Stop() {
+	s.mu.Lock()
+	ln := s.ln
+	s.ln = nil
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	s.mu.Unlock()
+	if ln != nil {
+		_ = ln.Close()
+	}
+	s.wg.Wait()
+}
+
+// 在原地址上恢复服务
+func (s *Server) Start() error {
+	var err error
+	for i := 0; i < 50; i++ { // 端口可能尚未释放
+		if err = s.listen(s.addr); err == nil {
+			return nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return err
+}
+
+func (s *Server) Close() {
+	s.Stop()
+}
+
+// 使所有会话过期，删除其临时节点。客户端重连时会收到会话过期的响应
+func (s *Server) ExpireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, sess := range s.sessions {
+		s.expireLocked(id)
+		if sess.conn != nil {
+			_ = sess.conn.Close()
+		}
+	}
+}
+
+// 返回节点数据，便于测试中检查
+func (s *Server) Get(p string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[p]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), n.data...), true
+}
+
+// 直接写入节点数据(节点不存在时创建，父节点需存在)，便于测试中构造数据
+func (s *Server) Set(p string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n, ok := s.nodes[p]; ok {
+		s.setLocked(n, data)
+		return nil
+	}
+	if _, code := s.createLocked(p, data, 0, 0); code != errOk {
+		return fmt.Errorf("create %s failed, code:%d", p, code)
+	}
+	return nil
+}
+
+// 修改节点的最后修改时间，毫秒，便于测试中模拟长时间未更新的节点
+func (s *Server) SetMtime(p string, mtime int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[p]
+	if !ok {
+		return fmt.Errorf("node %s not exists", p)
+	}
+	n.stat.Mtime = mtime
+	return nil
+}
+
+func (s *Server) accept(ln net.Listener) {
+	defer s.wg.Done()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(c)
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+			_ = c.Close()
+		}()
+	}
+}
+
+func readPacket(c net.Conn) ([]byte, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(c, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint32(lenBuf[:]))
+	_, err := io.ReadFull(c, buf)
+	return buf, err
+}
+
+func writePacket(c net.Conn, e *encoder) error {
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(e.buf)))
+	_, err := c.Write(append(out, e.buf...))
+	return err
+}
+
+func (s *Server) serve(c net.Conn) {
+	buf, err := readPacket(c)
+	if err != nil {
+		return
+	}
+	d := &decoder{buf: buf}
+	d.int32() // protocol version
+	d.int64() // last zxid seen
+	timeout := d.int32()
+	sessionId := d.int64()
+	passwd := d.bytes()
+	if d.err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	sess, ok := s.sessions[sessionId]
+	if sessionId != 0 && (!ok || string(sess.passwd) != string(passwd)) { // 会话已过期
+		s.mu.Unlock()
+		e := &encoder{}
+		e.int32(0)
+		e.int32(0)
+		e.int64(0)
+		e.bytes(make([]byte, 16))
+		_ = writePacket(c, e)
+		return
+	}
+	if sessionId == 0 {
+		s.lastSession++
+		sess = &session{id: s.lastSession, passwd: make([]byte, 16), timeout: timeout}
+		_, _ = rand.Read(sess.passwd)
+		s.sessions[sess.id] = sess
+	} else if sess.conn != nil {
+		_ = sess.conn.Close()
+	}
+	sess.conn = c
+	s.mu.Unlock()
+
+	e := &encoder{}
+	e.int32(0)
+	e.int32(sess.timeout)
+	e.int64(sess.id)
+	e.bytes(sess.passwd)
+	if writePacket(c, e) != nil {
+		return
+	}
+
+	for {
+		buf, err := readPacket(c)
+		if err != nil {
+			return
+		}
+		d := &decoder{buf: buf}
+		xid, op := d.int32(), d.int32()
+		resp, code, closing := s.handle(sess.id, op, d)
+		if d.err != nil {
+			return
+		}
+		s.mu.Lock()
+		zxid := s.zxid
+		s.mu.Unlock()
+		e := &encoder{}
+		e.int32(xid)
+		e.int64(zxid)
+		e.int32(code)
+		if code == errOk && resp != nil {
+			e.buf = append(e.buf, resp.buf...)
+		}
+		if writePacket(c, e) != nil || closing {
+			return
+		}
+	}
+}
+
+func (s *Server) handle(sessionId int64, op int32, d *decoder) (resp *encoder, code int32, closing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[sessionId]; !ok && op != opClose { // 会话在连接期间过期
+		return nil, errUnimplemented, true
+	}
+	resp = &encoder{}
+	switch op {
+	case opPing, opSetAuth, opSetWatches:
+	case opClose:
+		s.expireLocked(sessionId)
+		closing = true
+	case opCreate:
+		p, data := d.string(), d.bytes()
+		d.acls()
+		flags := d.int32()
+		var owner int64
+		if flags&zk.FlagEphemeral != 0 {
+			owner = sessionId
+		}
+		var created string
+		if created, code = s.createLocked(p, data, flags, owner); code == errOk {
+			resp.string(created)
+		}
+	case opDelete:
+		p, version := d.string(), d.int32()
+		code = s.deleteLocked(p, version)
+	case opExists, opGetData:
+		p := d.string()
+		d.bool()
+		n, ok := s.nodes[p]
+		if !ok {
+			return nil, errNoNode, false
+		}
+		if op == opGetData {
+			resp.bytes(n.data)
+		}
+		resp.stat(&n.stat)
+	case opSetData:
+		p, data, version := d.string(), d.bytes(), d.int32()
+		n, ok := s.nodes[p]
+		if !ok {
+			return nil, errNoNode, false
+		}
+		if version != -1 && version != n.stat.Version {
+			return nil, errBadVersion, false
+		}
+		s.setLocked(n, data)
+		resp.stat(&n.stat)
+	case opGetChildren, opGetChildren2:
+		p := d.string()
+		d.bool()
+		n, ok := s.nodes[p]
+		if !ok {
+			return nil, errNoNode, false
+		}
+		children := make([]string, 0, len(n.children))
+		for child := range n.children {
+			children = append(children, child)
+		}
+		sort.Strings(children)
+		resp.strings(children)
+		if op == opGetChildren2 {
+			resp.stat(&n.stat)
+		}
+	default:
+		code = errUnimplemented
+	}
+	return
+}
+
+func (s *Server) createLocked(p string, data []byte, flags int32, owner int64) (string, int32) {
+	parentPath := path.Dir(p)
+	parent, ok := s.nodes[parentPath]
+	if !ok {
+		return "", errNoNode
+	}
+	if parent.stat.EphemeralOwner != 0 {
+		return "", errNoChildrenEph
+	}
+	if flags&zk.FlagSequence != 0 {
+		p = fmt.Sprintf("%s%010d", p, parent.stat.Cversion)
+	}
+	if _, ok := s.nodes[p]; ok {
+		return "", errNodeExists
+	}
+	s.zxid++
+	now := time.Now().UnixNano() / 1e6
+	s.nodes[p] = &node{
+		data:     data,
+		children: map[string]struct{}{},
+		stat: zk.Stat{
+			Czxid:          s.zxid,
+			Mzxid:          s.zxid,
+			Ctime:          now,
+			Mtime:          now,
+			EphemeralOwner: owner,
+			DataLength:     int32(len(data)),
+			Pzxid:          s.zxid,
+		},
+	}
+	parent.children[strings.TrimPrefix(p[len(parentPath):], "/")] = struct{}{}
+	parent.stat.Cversion++
+	parent.stat.NumChildren++
+	parent.stat.Pzxid = s.zxid
+	return p, errOk
+}
+
+func (s *Server) deleteLocked(p string, version int32) int32 {
+	n, ok := s.nodes[p]
+	if !ok || p == "/" {
+		return errNoNode
+	}
+	if version != -1 && version != n.stat.Version {
+		return errBadVersion
+	}
+	if len(n.children) > 0 {
+		return errNotEmpty
+	}
+	s.zxid++
+	delete(s.nodes, p)
+	parentPath := path.Dir(p)
+	parent := s.nodes[parentPath]
+	delete(parent.children, strings.TrimPrefix(p[len(parentPath):], "/"))
+	parent.stat.Cversion++
+	parent.stat.NumChildren--
+	parent.stat.Pzxid = s.zxid
+	return errOk
+}
+
+func (s *Server) setLocked(n *node, data []byte) {
+	s.zxid++
+	n.data = data
+	n.stat.Version++
+	n.stat.Mzxid = s.zxid
+	n.stat.Mtime = time.Now().UnixNano() / 1e6
+	n.stat.DataLength = int32(len(data))
+}
+
+func (s *Server) expireLocked(sessionId int64) {
+	delete(s.sessions, sessionId)
+	var ephemerals []string
+	for p, n := range s.nodes {
+		if n.stat.EphemeralOwner == sessionId {
+			ephemerals = append(ephemerals, p)
+		}
+	}
+	for _, p := range ephemerals {
+		s.deleteLocked(p, -1)
+	}
+}
@@ -1,3 +1,6 @@
+-- MySQL建表语句，手动重建时使用。其它数据库见 repo/schema/ 目录
+-- 配置 db.autoCreateTable: true 时，启动时会自动执行 repo/schema/ 下对应数据库的建表语句
+
 DROP TABLE IF EXISTS `leaf_alloc`;
 
 CREATE TABLE `leaf_alloc` (
@@ -7,4 +10,18 @@ CREATE TABLE `leaf_alloc` (
 `description` varchar(256)  DEFAULT NULL,
 `update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 PRIMARY KEY (`biz_tag`)
-) ENGINE=InnoDB;
\ No newline at end of file
+) ENGINE=InnoDB;
+
+-- snowflake 模式 workerIdProvider=3 时使用
+DROP TABLE IF EXISTS `leaf_worker`;
+
+CREATE TABLE `leaf_worker` (
+`worker_id` int(11) NOT NULL,
+`endpoint` varchar(128) NOT NULL DEFAULT '',
+`lease_expire` bigint(20) NOT NULL DEFAULT '0',
+`last_timestamp` bigint(20) NOT NULL DEFAULT '0',
+`twepoch` bigint(20) NOT NULL DEFAULT '0',
+`version` bigint(20) NOT NULL DEFAULT '0',
+`update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+PRIMARY KEY (`worker_id`)
+) ENGINE=InnoDB;